@@ -0,0 +1,65 @@
+package main
+
+import (
+	"html"
+	"regexp"
+)
+
+// file to write the list of added and removed news items to
+var changelogFile string
+
+// news item as found in a feed file
+type feedEntry struct {
+	guid, title string
+}
+
+// extract GUIDs and titles from the given <item> elements
+func feedEntries(items [][]byte) []feedEntry {
+	res := make([]feedEntry, 0, len(items))
+
+	for _, item := range items {
+		var entry feedEntry
+
+		if m := matchGUID(item); len(m) == 2 {
+			entry.guid = string(m[1])
+		}
+
+		if m := matchTitle(item); len(m) == 2 {
+			entry.title = html.UnescapeString(string(m[1]))
+		}
+
+		res = append(res, entry)
+	}
+
+	return res
+}
+
+// compose the changelog with one line per news item, "+" for the items added, and "-" for
+// the items removed, followed by the GUID and the title
+func makeChangelog(before, after []feedEntry) []byte {
+	var buff []byte
+
+	buff = appendChanges(buff, '+', after, before)
+	buff = appendChanges(buff, '-', before, after)
+
+	return buff
+}
+
+// append the entries from the list that are not in the other list
+func appendChanges(dest []byte, mark byte, list, other []feedEntry) []byte {
+	guids := make(map[string]struct{}, len(other))
+
+	for _, entry := range other {
+		guids[entry.guid] = struct{}{}
+	}
+
+	for _, entry := range list {
+		if _, yes := guids[entry.guid]; !yes {
+			dest = append(append(append(append(append(dest, mark, ' '), entry.guid...), '\t'), entry.title...), '\n')
+		}
+	}
+
+	return dest
+}
+
+var matchTitle = regexp.MustCompile(`<title>([^<]*)</title>`).FindSubmatch
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// output character encoding, nil for UTF-8
+var outputCharmap *charmap.Charmap
+
+// name of the output character encoding, as in the XML declaration
+var outputEncoding = "UTF-8"
+
+// supported output encodings other than UTF-8
+var charmaps = map[string]*charmap.Charmap{
+	"windows-1251": charmap.Windows1251,
+}
+
+// set output character encoding
+func setEncoding(name string) error {
+	name = strings.ToLower(name)
+
+	if name == "utf-8" {
+		outputCharmap, outputEncoding = nil, "UTF-8"
+		return nil
+	}
+
+	if outputCharmap = charmaps[name]; outputCharmap == nil {
+		return errors.New("unsupported output encoding: " + strconv.Quote(name))
+	}
+
+	outputEncoding = name
+	return nil
+}
+
+// writer that transcodes UTF-8 to a single-byte encoding, replacing characters not
+// representable in the encoding with numeric character references
+type encodingWriter struct {
+	w          io.Writer
+	cm         *charmap.Charmap
+	buff, tail []byte
+}
+
+func (e *encodingWriter) Write(data []byte) (int, error) {
+	src := data
+
+	// incomplete character from the previous write
+	if len(e.tail) > 0 {
+		src = append(e.tail, data...)
+	}
+
+	buff := e.buff[:0]
+
+	for len(src) > 0 && utf8.FullRune(src) {
+		r, n := utf8.DecodeRune(src)
+
+		buff = appendEncodedRune(buff, e.cm, r)
+
+		src = src[n:]
+	}
+
+	e.tail = append(e.tail[:0], src...)
+	e.buff = buff
+
+	if _, err := e.w.Write(buff); err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+// append the given character in the given encoding, or as a numeric character reference
+// if it is not representable in the encoding
+func appendEncodedRune(dest []byte, cm *charmap.Charmap, r rune) []byte {
+	if b, ok := cm.EncodeRune(r); ok {
+		return append(dest, b)
+	}
+
+	return append(strconv.AppendInt(append(dest, "&#"...), int64(r), 10), ';')
+}
+
+// size of the given UTF-8 text in the output encoding
+func encodedLen(data []byte) int {
+	if outputCharmap == nil {
+		return len(data)
+	}
+
+	var buff [16]byte
+
+	n := 0
+
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		n += len(appendEncodedRune(buff[:0], outputCharmap, r))
+		data = data[size:]
+	}
+
+	return n
+}
+
+// length of the longest prefix of the given UTF-8 text that takes up at most n bytes
+// in the output encoding
+func encodedPrefixLen(data []byte, n int) int {
+	if outputCharmap == nil {
+		return min(max(n, 0), len(data))
+	}
+
+	var buff [16]byte
+
+	i := 0
+
+	for i < len(data) {
+		r, size := utf8.DecodeRune(data[i:])
+
+		if n -= len(appendEncodedRune(buff[:0], outputCharmap, r)); n < 0 {
+			break
+		}
+
+		i += size
+	}
+
+	return i
+}
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/maxim2266/vesti-rss/internal/app"
+	"github.com/maxim2266/vesti-rss/xmlutil"
+
+	"github.com/maxim2266/pump"
+)
+
+// item size limit, and the action for items over the limit
+var (
+	maxItemBytes int
+	oversized    string
+)
+
+// time zone for item timestamps
+var outputTZ = time.UTC
+
+// output size limit
+var maxOutputBytes int
+
+// write RSS document with the given channel header and news items
+func writeFeed(out io.Writer, header []byte, src pump.Gen[*NewsItem]) error {
+	if feedFormat == "rdf" {
+		return writeRDF(out, header, src)
+	}
+
+	w := newOutputWriter(out)
+
+	if maxOutputBytes > 0 && encodedLen(header)+encodedLen([]byte(xmlFooter)) > maxOutputBytes {
+		return errors.New("output size limit is too small even for an empty feed")
+	}
+
+	// XML header
+	if err := write(w, header); err != nil {
+		return err
+	}
+
+	// items
+	if err := writeItems(w, src); err != nil {
+		return err
+	}
+
+	// XML footer
+	return writeString(w, xmlFooter)
+}
+
+// write <item> elements for the given news items
+func writeItems(w *countingWriter, src pump.Gen[*NewsItem]) error {
+	// counters for integrity check
+	items, emitted := w.items, stats.emitted
+
+	// buffers
+	buff := append(make([]byte, 0, 4*1024), xmlPrefix...)
+
+	var tail []byte
+
+	err := src(func(news *NewsItem) error {
+		var descStart, descEnd int
+
+		if feedTemplate != nil {
+			var err error
+
+			if buff, err = renderItem(buff[:0], news); err != nil {
+				return err
+			}
+		} else {
+			// elements
+			buff = buff[:xmlPrefixLen]
+
+			for _, elem := range itemOrder {
+				start := len(buff)
+				buff = elem.write(buff, news)
+
+				if elem.name == "description" {
+					descStart, descEnd = start+len("<description>"), len(buff)-len("</description>")
+
+					// the "read more" link is not truncated
+					if len(readMore) > 0 {
+						tail = xmlutil.AppendEscaped(tail[:0], readMoreSuffix(news))
+						descEnd -= len(tail)
+					}
+				}
+			}
+
+			buff = append(append(buff, "</item>"...), itemEnd...)
+		}
+
+		// size limit
+		if size := encodedLen(buff); maxItemBytes > 0 && size > maxItemBytes {
+			over, desc := size-maxItemBytes, buff[descStart:descEnd]
+			descLen := encodedLen(desc)
+
+			if truncDesc := oversized == "truncate" && over <= descLen; !truncDesc {
+				app.Warn("skipped news item %d: item size of %d bytes is over the limit", news.id, size)
+				stats.skipped++
+				return nil // skip
+			}
+
+			tail = append(tail[:0], buff[descEnd:]...)
+			ellipsisLen := encodedLen([]byte(ellipsis))
+
+			if n := descLen - over - ellipsisLen; n >= 0 {
+				buff = buff[:descStart+len(xmlutil.TruncateEscaped(desc, encodedPrefixLen(desc, n)))]
+				buff = append(bytes.TrimRightFunc(buff, unicode.IsSpace), ellipsis...)
+			} else {
+				buff = buff[:descStart+len(xmlutil.TruncateEscaped(desc, encodedPrefixLen(desc, n+ellipsisLen)))]
+			}
+
+			buff = append(buff, tail...)
+
+			app.Info("truncated description of news item %d to fit the size limit", news.id)
+		}
+
+		// output size limit
+		if maxOutputBytes > 0 && w.bytes+encodedLen(buff)+encodedLen([]byte(xmlFooter)) > maxOutputBytes {
+			return errOutputFull
+		}
+
+		// write
+		if err := write(w, buff); err != nil {
+			return err
+		}
+
+		countEmitted(news)
+		return nil
+	})
+
+	if err == errOutputFull {
+		app.Warn("output truncated to %d news items to fit the size limit", stats.emitted-emitted)
+		err = nil
+	}
+
+	// integrity check; a template may produce elements other than <item>
+	if err == nil && feedTemplate == nil && w.items-items != stats.emitted-emitted {
+		err = errors.New("internal error: " + strconv.Itoa(w.items-items) + " items written out, but " +
+			strconv.Itoa(stats.emitted-emitted) + " items emitted")
+	}
+
+	return err
+}
+
+// update statistics for the news item written out, and send it to syslog if enabled
+func countEmitted(news *NewsItem) {
+	stats.emitted++
+
+	if itemSyslog != nil {
+		sendItemSyslog(news)
+	}
+
+	if stats.newest.IsZero() || news.ts.After(stats.newest) {
+		stats.newest = news.ts
+	}
+
+	if stats.oldest.IsZero() || news.ts.Before(stats.oldest) {
+		stats.oldest = news.ts
+	}
+}
+
+// stops the news source when the output size limit is reached
+var errOutputFull = errors.New("output size limit reached")
+
+// make counting writer for the final output, transcoding it to the output encoding if required
+func newOutputWriter(out io.Writer) *countingWriter {
+	if outputCharmap != nil {
+		out = &encodingWriter{w: out, cm: outputCharmap}
+	}
+
+	return &countingWriter{w: out}
+}
+
+// writer wrapper that counts bytes and <item> elements written; the bytes are counted
+// in the output encoding
+type countingWriter struct {
+	w            io.Writer
+	bytes, items int
+}
+
+func (c *countingWriter) Write(data []byte) (n int, err error) {
+	n, err = c.w.Write(data)
+	c.bytes += encodedLen(data[:n])
+	c.items += bytes.Count(data[:n], []byte("<item>"))
+	return
+}
+
+// compose channel header
+func makeHeader(skipHours, skipDays string) ([]byte, error) {
+	hours, err := parseSkipHours(skipHours)
+
+	if err != nil {
+		return nil, err
+	}
+
+	days, err := parseSkipDays(skipDays)
+
+	if err != nil {
+		return nil, err
+	}
+
+	year := copyrightYear
+
+	if year == 0 {
+		year = app.Now().Year()
+	}
+
+	if feedFormat == "rdf" {
+		return makeRDFHeader(year), nil
+	}
+
+	if feedTemplate != nil {
+		return renderHeader(headerData{
+			Encoding:         outputEncoding,
+			Provenance:       provenance(),
+			Year:             year,
+			XMLBase:          xmlBase,
+			IconURL:          iconURL,
+			ImageDescription: imageDescription,
+			SkipHours:        hours,
+			SkipDays:         days,
+		})
+	}
+
+	header := appendProvenance(append(append([]byte(xmlDecl), outputEncoding...), xmlDeclEnd...))
+	header = append(header, xmlHeader...)
+
+	// root element attributes
+	if len(xmlBase) > 0 {
+		header = xmlutil.AppendAttr(header, "xml:base", xmlBase)
+	}
+
+	if len(iconURL) > 0 {
+		header = xmlutil.AppendAttr(header, "xmlns:webfeeds", webfeedsNS)
+	}
+
+	header = append(strconv.AppendInt(append(header, xmlChannel...), int64(year), 10), xmlHeaderTail...)
+
+	// channel image
+	if len(imageDescription) > 0 {
+		header = append(xmlutil.AppendEscaped(append(header, "    <description>"...), imageDescription), "</description>\n"...)
+	}
+
+	header = append(header, xmlImageEnd...)
+
+	// channel icon
+	if len(iconURL) > 0 {
+		header = append(xmlutil.AppendTag(append(header, "  "...), "webfeeds:icon", iconURL), '\n')
+	}
+
+	header = appendSkipDays(appendSkipHours(header, hours), days)
+
+	if compact {
+		header = compactXML(header)
+	}
+
+	return crlfLines(header), nil
+}
+
+// enables the comment on how the feed was generated, after the XML declaration
+var embedProvenance bool
+
+// text of the comment on how the feed was generated, or an empty string if not enabled;
+// the text never contains "--", so it can be put in an XML comment as is
+func provenance() string {
+	if !embedProvenance {
+		return ""
+	}
+
+	text := "generated by vesti-rss/" + version + " at " + app.Now().UTC().Format(time.RFC3339)
+
+	flag.Visit(func(f *flag.Flag) {
+		text += " -" + f.Name + "=" + strconv.Quote(flagValue(f))
+	})
+
+	for strings.Contains(text, "--") {
+		text = strings.ReplaceAll(text, "--", "- -")
+	}
+
+	return text
+}
+
+// append the comment on how the feed was generated, if enabled
+func appendProvenance(dest []byte) []byte {
+	if text := provenance(); len(text) > 0 {
+		dest = append(append(append(dest, "<!-- "...), text...), " -->\n"...)
+	}
+
+	return dest
+}
+
+// year in the copyright notice, 0 for the current year
+var copyrightYear int
+
+// URL of the feed icon for the <webfeeds:icon> channel element
+var iconURL string
+
+// namespace of the webfeeds elements
+const webfeedsNS = "http://webfeeds.org/rss/1.0"
+
+// base URL for the xml:base attribute of the root element
+var xmlBase string
+
+// optional description of the channel image
+var imageDescription string
+
+// enables compact output
+var compact bool
+
+// switch to compact output
+func setCompact() {
+	compact = true
+	xmlFooter = "</channel></rss>"
+	itemEnd = ""
+}
+
+// line ending in the output, either "\n" or "\r\n"
+var newline = "\n"
+
+// set output line ending, one of: lf, crlf
+func setLineEnding(name string) error {
+	switch name {
+	case "lf":
+		newline = "\n"
+	case "crlf":
+		newline = "\r\n"
+	default:
+		return errors.New("invalid line ending: " + strconv.Quote(name))
+	}
+
+	xmlFooter = strings.ReplaceAll(xmlFooter, "\n", newline)
+	itemEnd = strings.ReplaceAll(itemEnd, "\n", newline)
+	return nil
+}
+
+// convert the line endings of the given document part to the output line ending
+func crlfLines(src []byte) []byte {
+	if newline == "\n" {
+		return src
+	}
+
+	return bytes.ReplaceAll(src, []byte("\n"), []byte(newline))
+}
+
+// remove whitespace between XML tags, in place; the input must not contain mixed content
+func compactXML(src []byte) []byte {
+	dest := src[:0]
+
+	for i := 0; i < len(src); i++ {
+		if isSpace(src[i]) && len(dest) > 0 && dest[len(dest)-1] == '>' {
+			j := i + 1
+
+			for j < len(src) && isSpace(src[j]) {
+				j++
+			}
+
+			if j == len(src) || src[j] == '<' {
+				i = j - 1
+				continue
+			}
+		}
+
+		dest = append(dest, src[i])
+	}
+
+	return dest
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// XML header, split around the encoding, copyright year, and other variable parts
+const (
+	xmlDecl = `<?xml version="1.0" encoding="`
+
+	xmlDeclEnd = "\"?>\n"
+
+	xmlHeader = `<rss version="2.0"`
+
+	xmlChannel = `>
+<channel>
+  <title>Новости</title>
+  <link>https://www.vesti.ru/news</link>
+  <description>Новости дня от Вести.Ru, интервью, репортажи, фото и видео, новости Москвы и регионов России, новости экономики, погода</description>
+  <copyright>© `
+
+	xmlHeaderTail = ` Сетевое издание &quot;Вести.Ру&quot;</copyright>
+  <image>
+    <link>https://www.vesti.ru/news</link>
+    <title>Новости</title>
+    <url>https://www.vesti.ru/i/logo_fb.png</url>
+`
+
+	xmlImageEnd = "  </image>\n"
+)
+
+// parse comma-separated list of hours for the <skipHours> channel element; the result is sorted
+func parseSkipHours(list string) (res []int, err error) {
+	var hours [24]bool
+
+	for _, s := range splitList(list) {
+		hour, err := strconv.Atoi(s)
+
+		if err != nil || hour < 0 || hour > 23 {
+			return nil, errors.New("invalid hour in skip list: " + strconv.Quote(s))
+		}
+
+		hours[hour] = true
+	}
+
+	for hour, yes := range hours {
+		if yes {
+			res = append(res, hour)
+		}
+	}
+
+	return
+}
+
+// parse comma-separated list of week days for the <skipDays> channel element; the result is sorted
+func parseSkipDays(list string) (res []string, err error) {
+	var days [7]bool
+
+	for _, s := range splitList(list) {
+		i := 0
+
+		for i < len(days) && !strings.EqualFold(s, time.Weekday(i).String()) {
+			i++
+		}
+
+		if i == len(days) {
+			return nil, errors.New("invalid week day in skip list: " + strconv.Quote(s))
+		}
+
+		days[i] = true
+	}
+
+	for i, yes := range days {
+		if yes {
+			res = append(res, time.Weekday(i).String())
+		}
+	}
+
+	return
+}
+
+// append <skipHours> channel element for the given hours
+func appendSkipHours(dest []byte, hours []int) []byte {
+	if len(hours) == 0 {
+		return dest // omitted
+	}
+
+	dest = append(dest, "  <skipHours>\n"...)
+
+	for _, hour := range hours {
+		dest = append(strconv.AppendInt(append(dest, "    <hour>"...), int64(hour), 10), "</hour>\n"...)
+	}
+
+	return append(dest, "  </skipHours>\n"...)
+}
+
+// append <skipDays> channel element for the given week days
+func appendSkipDays(dest []byte, days []string) []byte {
+	if len(days) == 0 {
+		return dest // omitted
+	}
+
+	dest = append(dest, "  <skipDays>\n"...)
+
+	for _, day := range days {
+		dest = append(append(append(dest, "    <day>"...), day...), "</day>\n"...)
+	}
+
+	return append(dest, "  </skipDays>\n"...)
+}
+
+// split comma-separated list, ignoring empty elements
+func splitList(list string) (res []string) {
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); len(s) > 0 {
+			res = append(res, s)
+		}
+	}
+
+	return
+}
+
+// <item> child element writer
+type itemElement struct {
+	name  string
+	write func([]byte, *NewsItem) []byte
+}
+
+// all <item> child elements, in the default order
+var itemElements = [...]itemElement{
+	{"title", func(dest []byte, news *NewsItem) []byte {
+		return xmlutil.AppendTag(dest, "title", news.title)
+	}},
+	{"description", func(dest []byte, news *NewsItem) []byte {
+		dest = xmlutil.AppendEscaped(append(dest, "<description>"...), news.text)
+		return append(xmlutil.AppendEscaped(dest, readMoreSuffix(news)), "</description>"...)
+	}},
+	{"link", func(dest []byte, news *NewsItem) []byte {
+		return xmlutil.AppendTag(dest, "link", news.link)
+	}},
+	{"guid", func(dest []byte, news *NewsItem) []byte {
+		return append(xmlutil.AppendEscaped(append(dest, `<guid isPermaLink="false">`...), news.guid), "</guid>"...)
+	}},
+	{"pubDate", func(dest []byte, news *NewsItem) []byte {
+		return append(news.ts.In(outputTZ).AppendFormat(append(dest, "<pubDate>"...), time.RFC1123Z), "</pubDate>"...)
+	}},
+}
+
+// label of the link to the article at the end of each description, omitted if empty
+var readMore string
+
+// text to append to the description of the given news item
+func readMoreSuffix(news *NewsItem) string {
+	switch {
+	case len(readMore) == 0:
+		return ""
+	case len(news.text) == 0:
+		return readMore + ": " + news.link
+	default:
+		return " " + readMore + ": " + news.link
+	}
+}
+
+// the order of <item> child elements in the output
+var itemOrder = itemElements[:]
+
+// set the order of <item> child elements from the given comma-separated list of names;
+// each element must be listed exactly once
+func setItemOrder(list string) error {
+	names := splitList(list)
+	order := make([]itemElement, 0, len(itemElements))
+
+	for _, name := range names {
+		i := slices.IndexFunc(itemElements[:], func(elem itemElement) bool { return elem.name == name })
+
+		if i < 0 {
+			return errors.New("unknown item element: " + strconv.Quote(name))
+		}
+
+		if slices.Contains(names[:len(order)], name) {
+			return errors.New("duplicate item element: " + strconv.Quote(name))
+		}
+
+		order = append(order, itemElements[i])
+	}
+
+	if len(order) != len(itemElements) {
+		return errors.New("item element order must list all of: title, description, link, guid, pubDate")
+	}
+
+	itemOrder = order
+	return nil
+}
+
+// XML footer and item terminator, both without whitespace in the compact mode
+var (
+	xmlFooter = "</channel>\n</rss>\n"
+	itemEnd   = "\n"
+)
+
+const (
+	xmlPrefix    = "<item>"
+	xmlPrefixLen = len(xmlPrefix)
+
+	ellipsis = "…"
+)
+
+// output writers
+func write(w io.Writer, data []byte) (err error) {
+	if _, err = w.Write(data); err != nil {
+		err = failure("writing output", err)
+	}
+
+	return
+}
+
+func writeString(w io.Writer, data string) (err error) {
+	if _, err = io.WriteString(w, data); err != nil {
+		err = failure("writing output", err)
+	}
+
+	return
+}
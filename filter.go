@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/maxim2266/vesti-rss/internal/app"
+
+	"github.com/maxim2266/pump"
+)
+
+// external filter command
+var filterCmd string
+
+// action for items with dead links
+var validateLinks string
+
+// JSON representation of a news item
+type itemJSON struct {
+	ID    uint64    `json:"id"`
+	Title string    `json:"title"`
+	Text  string    `json:"text"`
+	Link  string    `json:"link"`
+	TS    time.Time `json:"ts"`
+}
+
+func toJSON(news *NewsItem) itemJSON {
+	return itemJSON{
+		ID:    news.id,
+		Title: news.title,
+		Text:  news.text,
+		Link:  news.link,
+		TS:    news.ts,
+	}
+}
+
+// Pipeline stage that passes each news item as JSON to the STDIN of the external command, and
+// keeps the item only if the command exits with code 0. If the command writes a JSON object to its
+// STDOUT, the object replaces the item, except the ID which cannot be changed.
+func filterByCmd(src pump.Gen[*NewsItem], yield func(*NewsItem) error) error {
+	var stdout bytes.Buffer
+
+	return src(func(news *NewsItem) error {
+		input, err := json.Marshal(toJSON(news))
+
+		if err != nil {
+			return failure("filter command input", err)
+		}
+
+		// run the command
+		cmd := exec.CommandContext(app.Context(), "/bin/sh", "-c", filterCmd)
+
+		stdout.Reset()
+
+		cmd.Stdin = bytes.NewReader(input)
+		cmd.Stdout = &stdout
+		cmd.Stderr = os.Stderr
+
+		if err = cmd.Run(); err != nil {
+			if ee := (*exec.ExitError)(nil); errors.As(err, &ee) && app.Running() {
+				app.Info("news item %d rejected by filter command (exit code %d)", news.id, ee.ExitCode())
+				stats.skipped++
+				return nil // skip
+			}
+
+			return failure("filter command", err)
+		}
+
+		// item transformation
+		if out := bytes.TrimSpace(stdout.Bytes()); len(out) > 0 {
+			res := toJSON(news)
+
+			if err = json.Unmarshal(out, &res); err != nil {
+				return failure("filter command output", err)
+			}
+
+			if res.ID != news.id {
+				return errors.New("filter command changed ID of the news item " + strconv.FormatUint(news.id, 10))
+			}
+
+			if u, err := url.ParseRequestURI(res.Link); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return errors.New("filter command produced an invalid link for the news item " + strconv.FormatUint(news.id, 10))
+			}
+
+			news.title, news.text, news.link, news.ts = res.Title, res.Text, res.Link, res.TS.UTC()
+		}
+
+		return yield(news)
+	})
+}
+
+// pipeline stage that checks the link of each news item, and drops or just reports the items
+// with links that do not respond with 2xx or 3xx status code
+func linkValidator(client *http.Client) pump.Stage[*NewsItem, *NewsItem] {
+	return func(src pump.Gen[*NewsItem], yield func(*NewsItem) error) error {
+		dead := 0
+
+		err := src(func(news *NewsItem) error {
+			if err := checkLink(client, news.link); err != nil {
+				if !app.Running() {
+					return app.Context().Err()
+				}
+
+				dead++
+
+				if validateLinks == "drop" {
+					app.Warn("skipped news item %d: dead link %s: %s", news.id, news.link, err)
+					stats.skipped++
+					return nil // skip
+				}
+
+				app.Warn("news item %d has a dead link %s: %s", news.id, news.link, err)
+			}
+
+			return yield(news)
+		})
+
+		if dead > 0 {
+			app.Warn("found %d dead links", dead)
+		} else {
+			app.Info("found no dead links")
+		}
+
+		return err
+	}
+}
+
+// check if the link responds with 2xx or 3xx status code; redirects are not followed, because
+// a redirect (e.g., from http to https) means the link is alive. Servers that do not support HEAD
+// are tried with a GET for the first byte only.
+func checkLink(client *http.Client, link string) error {
+	status, err := probeLink(client, http.MethodHead, link)
+
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = probeLink(client, http.MethodGet, link)
+	}
+
+	switch {
+	case err != nil:
+		return err
+	case status < 200 || status > 399:
+		return errors.New("status code " + strconv.Itoa(status))
+	default:
+		return nil
+	}
+}
+
+func probeLink(client *http.Client, method, link string) (int, error) {
+	req, err := newRequest(app.Context(), method, link, "*/*")
+
+	if err != nil {
+		return 0, err
+	}
+
+	if method == http.MethodGet {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return 0, err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	return resp.StatusCode, nil
+}
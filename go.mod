@@ -1,5 +1,7 @@
-module vesti-rss
+module github.com/maxim2266/vesti-rss
 
-go 1.23
+go 1.23.0
 
 require github.com/maxim2266/pump v0.7.0
+
+require golang.org/x/text v0.28.0
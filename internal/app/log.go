@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 // Trace writes tracing message to STDERR.
@@ -24,6 +25,14 @@ func Info(msg string, args ...any) {
 	}
 }
 
+// InfoKV writes informational message to STDERR, followed by the given key/value pairs
+// formatted as key=value, with string values quoted.
+func InfoKV(msg string, kv ...any) {
+	if level <= levelInfo {
+		write("info", formatKV([]byte(msg), kv))
+	}
+}
+
 // Warn writes warning message to STDERR.
 func Warn(msg string, args ...any) {
 	if level <= levelWarn {
@@ -36,15 +45,63 @@ func Error(msg string, args ...any) {
 	writeErr(1, msg, args...)
 }
 
+// ErrorCode writes error message to STDERR and requests application shutdown
+// with the given exit code.
+func ErrorCode(code int, msg string, args ...any) {
+	writeErr(int32(code), msg, args...)
+}
+
+// ErrorMessage returns the message of the error that caused application shutdown,
+// or an empty string if there was no error.
+func ErrorMessage() string {
+	if p := errMsg.Load(); p != nil {
+		return *p
+	}
+
+	return ""
+}
+
+// WarnCode writes warning message to STDERR and sets the exit code the application
+// terminates with if no error is reported, without requesting shutdown.
+func WarnCode(ret int, msg string, args ...any) {
+	warnCode.CompareAndSwap(0, int32(ret))
+	Warn(msg, args...)
+}
+
 func writeErr(ret int32, msg string, args ...any) {
 	if code.CompareAndSwap(0, ret) {
-		write("error", msg, args...)
+		if len(args) > 0 {
+			msg = fmt.Sprintf(msg, args...)
+		}
+
+		errMsg.Store(&msg)
+		write("error", msg)
 		Shutdown()
 	} else {
 		Warn(msg, args...)
 	}
 }
 
+func formatKV(buff []byte, kv []any) string {
+	for i := 0; i < len(kv); i += 2 {
+		buff = append(append(buff, ' '), fmt.Sprint(kv[i])...)
+
+		if i+1 == len(kv) {
+			buff = append(buff, "=<missing>"...)
+			break
+		}
+
+		switch v := kv[i+1].(type) {
+		case string:
+			buff = strconv.AppendQuote(append(buff, '='), v)
+		default:
+			buff = fmt.Append(append(buff, '='), v)
+		}
+	}
+
+	return string(buff)
+}
+
 // This function does the actual writing.
 func write(kind, msg string, args ...any) {
 	addr := pool.Get().(*[]byte)
@@ -121,4 +178,7 @@ var (
 
 	// tracing level
 	level = levelInfo
+
+	// error message
+	errMsg atomic.Pointer[string]
 )
@@ -5,6 +5,7 @@
 //   - Goroutines that are waited upon before application exit;
 //   - Application lifetime control via main context;
 //   - Formatted logging to STDERR;
+//   - Exit handlers with controlled ordering;
 package app
 
 import (
@@ -14,6 +15,7 @@ import (
 	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 )
 
 // Shutdown terminates the main application context.
@@ -31,6 +33,13 @@ func Running() bool { return ctx.Err() == nil }
 // Failed checks if the application is shutting down with a non-zero exit code.
 func Failed() bool { return code.Load() != 0 }
 
+// ExitCode returns the exit code the application is going to terminate with.
+func ExitCode() int { return int(exitCode()) }
+
+// Now returns the current time; all time-dependent application code should call this
+// function instead of time.Now(), so that tests can substitute a fixed clock.
+var Now = time.Now
+
 // Go invokes the given function in a separate goroutine registered with the runtime,
 // so that the application will wait for the function to complete before exiting.
 // Any non-nil error from the function is reported via app.Error(), causing application shutdown.
@@ -77,11 +86,63 @@ func Run(fn func() error) {
 	// wait for all goroutines to terminate
 	wg.Wait()
 
+	// exit handlers
+	runExitHandlers()
+
 	// exit
-	os.Exit(int(code.Load()))
+	os.Exit(int(exitCode()))
+}
+
+// error code, if any, otherwise the code from app.WarnCode()
+func exitCode() int32 {
+	if ret := code.Load(); ret != 0 {
+		return ret
+	}
+
+	return warnCode.Load()
+}
+
+// AtExit registers the given function to be called upon application exit, after all
+// goroutines started via app.Go() have terminated. The functions are called in the reverse
+// order of their registration (LIFO), so a resource acquired later gets released earlier.
+func AtExit(fn func()) {
+	exitMu.Lock()
+	defer exitMu.Unlock()
+
+	exitLIFO = append(exitLIFO, fn)
+}
+
+// AtExitFirst registers the given function to be called upon application exit after
+// all the functions registered via app.AtExit(), i.e., truly last. Functions registered
+// via app.AtExitFirst() are called in the order of their registration (FIFO).
+func AtExitFirst(fn func()) {
+	exitMu.Lock()
+	defer exitMu.Unlock()
+
+	exitFIFO = append(exitFIFO, fn)
+}
+
+func runExitHandlers() {
+	exitMu.Lock()
+	lifo, fifo := exitLIFO, exitFIFO
+	exitLIFO, exitFIFO = nil, nil
+	exitMu.Unlock()
+
+	for i := len(lifo) - 1; i >= 0; i-- {
+		lifo[i]()
+	}
+
+	for _, fn := range fifo {
+		fn()
+	}
 }
 
 var (
-	wg   sync.WaitGroup // wait group for all registered goroutines.
-	code atomic.Int32   // application return code
+	wg       sync.WaitGroup // wait group for all registered goroutines.
+	code     atomic.Int32   // application return code
+	warnCode atomic.Int32   // return code set by app.WarnCode(), unless there is an error
+
+	// exit handlers
+	exitMu             sync.Mutex
+	exitLIFO, exitFIFO []func()
 )
@@ -0,0 +1,35 @@
+package app
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestExitHandlerOrder(t *testing.T) {
+	var calls []string
+
+	add := func(name string) func() {
+		return func() { calls = append(calls, name) }
+	}
+
+	AtExitFirst(add("first 1"))
+	AtExit(add("lifo 1"))
+	AtExitFirst(add("first 2"))
+	AtExit(add("lifo 2"))
+	AtExit(add("lifo 3"))
+
+	runExitHandlers()
+
+	exp := []string{"lifo 3", "lifo 2", "lifo 1", "first 1", "first 2"}
+
+	if !slices.Equal(calls, exp) {
+		t.Fatalf("unexpected order of exit handlers: %q, expected %q", calls, exp)
+	}
+
+	// the handlers are called only once
+	runExitHandlers()
+
+	if len(calls) != len(exp) {
+		t.Fatalf("exit handlers called again: %q", calls)
+	}
+}
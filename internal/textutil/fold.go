@@ -0,0 +1,49 @@
+// Package textutil provides text matching helpers that are aware of Russian spelling.
+package textutil
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Fold returns the given string in a form suitable for case-insensitive matching: all letters
+// are lower-cased, and the letter "ё" is replaced with "е", as it is commonly written in Russian.
+func Fold(s string) string {
+	return strings.Map(foldRune, s)
+}
+
+// ContainsFold checks if the haystack contains the needle, ignoring letter case and the difference
+// between "ё" and "е".
+func ContainsFold(haystack, needle string) bool {
+	return strings.Contains(Fold(haystack), Fold(needle))
+}
+
+// Normalize returns the folded form of the given string, with all punctuation removed
+// and whitespace collapsed, for detection of near-identical texts.
+func Normalize(s string) string {
+	var b strings.Builder
+
+	b.Grow(len(s))
+
+	for _, r := range s {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(foldRune(r))
+		case unicode.IsSpace(r):
+			if b.Len() > 0 && !strings.HasSuffix(b.String(), " ") {
+				b.WriteByte(' ')
+			}
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), " ")
+}
+
+func foldRune(r rune) rune {
+	switch r {
+	case 'ё', 'Ё':
+		return 'е'
+	default:
+		return unicode.ToLower(r)
+	}
+}
@@ -0,0 +1,63 @@
+package textutil
+
+import "testing"
+
+func TestFold(t *testing.T) {
+	cases := []struct{ src, exp string }{
+		{"", ""},
+		{"Ёлка", "елка"},
+		{"ЁЖИК и ёжик", "ежик и ежик"},
+		{"ПРИВЕТ, World!", "привет, world!"},
+		{"Αθήνα и Москва", "αθήνα и москва"},
+	}
+
+	for _, c := range cases {
+		if got := Fold(c.src); got != c.exp {
+			t.Errorf("Fold(%q): got %q, expected %q", c.src, got, c.exp)
+		}
+	}
+}
+
+func TestContainsFold(t *testing.T) {
+	cases := []struct {
+		haystack, needle string
+		exp              bool
+	}{
+		{"Новогодняя ёлка на Красной площади", "ЕЛКА", true},
+		{"Новогодняя елка на Красной площади", "Ёлка", true},
+		{"Новогодняя ЁЛКА", "ёлка", true},
+		{"Курс евро на ЦБ РФ", "цб рф", true},
+		{"Матч Zenit – Спартак", "zenit – спартак", true},
+		{"Новости дня", "", true},
+		{"", "новости", false},
+		{"Новости дня", "ночи", false},
+
+		// Latin "o" and "c" look the same as Cyrillic "о" and "с", but do not match them
+		{"Москва", "Mocква", false},
+		{"Mockва", "москва", false},
+	}
+
+	for _, c := range cases {
+		if got := ContainsFold(c.haystack, c.needle); got != c.exp {
+			t.Errorf("ContainsFold(%q, %q): got %t, expected %t", c.haystack, c.needle, got, c.exp)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	cases := []struct{ src, exp string }{
+		{"", ""},
+		{"  ...  ", ""},
+		{"Ёлку установили!", "елку установили"},
+		{"«Ёлку»   установили —\tв Москве", "елку установили в москве"},
+		{"  В Москве: снег, -5°C ", "в москве снег 5c"},
+		{"Zenit — ЧЕМПИОН!!!", "zenit чемпион"},
+		{"COVID-19 и ОРВИ", "covid19 и орви"},
+	}
+
+	for _, c := range cases {
+		if got := Normalize(c.src); got != c.exp {
+			t.Errorf("Normalize(%q): got %q, expected %q", c.src, got, c.exp)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// remove query string from item links
+var stripQuery bool
+
+// substitutions to apply to item links, in order
+var linkRewrites []linkRewrite
+
+// substring replacement in item links
+type linkRewrite struct {
+	old, new string
+}
+
+// flag.Value for the list of link substitutions
+type rewriteList struct{}
+
+func (rewriteList) String() string {
+	list := make([]string, len(linkRewrites))
+
+	for i, r := range linkRewrites {
+		list[i] = r.old + "=" + r.new
+	}
+
+	return strings.Join(list, ",")
+}
+
+func (rewriteList) Set(s string) error {
+	old, new, ok := strings.Cut(s, "=")
+
+	if !ok {
+		return errors.New("missing \"=\" in link substitution " + strconv.Quote(s))
+	}
+
+	if len(old) == 0 {
+		return errors.New("empty substring to replace in link substitution " + strconv.Quote(s))
+	}
+
+	linkRewrites = append(linkRewrites, linkRewrite{old, new})
+	return nil
+}
+
+// apply the configured rewrites to the given link, and check that the result is still
+// a valid HTTP(S) URL
+func rewriteLink(link string) (string, error) {
+	if stripQuery {
+		u, err := url.Parse(link)
+
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrBadURL, err)
+		}
+
+		u.RawQuery, u.ForceQuery = "", false
+		link = u.String()
+	}
+
+	for _, r := range linkRewrites {
+		link = strings.ReplaceAll(link, r.old, r.new)
+	}
+
+	if u, err := url.ParseRequestURI(link); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return "", fmt.Errorf("%w: invalid link after rewriting: %s", ErrBadURL, strconv.Quote(link))
+	}
+
+	return link, nil
+}
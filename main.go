@@ -2,19 +2,36 @@ package main
 
 import (
 	"bytes"
+	"cmp"
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
 	"io"
+	"math/rand/v2"
+	"mime"
+	"net"
 	"net/http"
+	"net/textproto"
 	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
-	"vesti-rss/internal/app"
-	"vesti-rss/internal/xmlutil"
+	"github.com/maxim2266/vesti-rss/internal/app"
+	"github.com/maxim2266/vesti-rss/internal/textutil"
 
 	"github.com/maxim2266/pump"
 )
@@ -27,257 +44,1778 @@ const (
 
 // entry point
 func main() {
-	os.Stdin.Close()
+	// STDIN is closed unless it is going to be read from
+	if !slices.Contains(os.Args[1:], "@-") {
+		os.Stdin.Close()
+	}
+
 	app.Run(theApp)
 }
 
-// the application
-func theApp() (err error) {
-	// load MSK location
-	if msk, err = time.LoadLocation("Europe/Moscow"); err != nil {
-		return
+// the application
+func theApp() (err error) {
+	start := app.Now()
+
+	// load MSK location
+	if msk, err = time.LoadLocation("Europe/Moscow"); err != nil {
+		return
+	}
+
+	// read flags
+	var (
+		numItems             int
+		perCategoryLimit     int
+		previewItems         int
+		warnBelow, failBelow int
+		logLevel             string
+		skipHours, skipDays  string
+		dedupBy, tz          string
+		itemOrder            string
+		urlPattern           string
+		blocklistFile        string
+		outputFIFO           string
+		seed                 uint64
+		headerOnly, uaDetail bool
+		warmupOnly           bool
+		listSectionsOnly     bool
+		estimateOnly         bool
+		countOnly            bool
+		dedupeTitle          bool
+		stableOrder          bool
+		compactOutput        bool
+		templateFile         string
+		encoding             string
+		lineEnding           string
+		printTemplate        bool
+		emitSyslog           string
+	)
+
+	flag.IntVar(&numItems, "num-items", 100, "number of news items to emit, from 1 to 500; the actual number will be rounded up to the page size")
+	flag.StringVar(&logLevel, "log-level", "error", "logging level, one of: trace, info, warning, error")
+	flag.StringVar(&skipHours, "skip-hours", "", "comma-separated list of hours (0 to 23, GMT) to advertise in the <skipHours> channel element")
+	flag.StringVar(&skipDays, "skip-days", "", "comma-separated list of week days (Monday to Sunday) to advertise in the <skipDays> channel element")
+	flag.DurationVar(&httpTimeout, "http-timeout", 5*time.Second, "timeout for the whole HTTP request, including reading the response body")
+	flag.DurationVar(&dialTimeout, "dial-timeout", 0, "timeout for establishing a connection, 0 for no limit other than -http-timeout")
+	flag.DurationVar(&tlsTimeout, "tls-timeout", 0, "timeout for TLS handshake, 0 for no limit other than -http-timeout")
+	flag.DurationVar(&pageDeadline, "page-deadline", 0, "time limit for fetching and processing each page, 0 for no limit")
+	flag.DurationVar(&stallTimeout, "stall-timeout", 0, "abort the HTTP request if no data of the response body arrive within the given time,\n0 for no limit other than -http-timeout")
+	flag.DurationVar(&startJitter, "start-jitter", 0, "delay the first request by a random time up to the given duration, to spread the load\nfrom multiple instances started at the same time")
+	flag.UintVar(&memLimit, "mem-limit", 0, "heap size limit in MiB, after which the feed is written with the news collected so far;\n0 for no limit")
+	flag.BoolVar(&streamInput, "stream", false, "request pages in NDJSON format; if the server responds in that format, the news items are\ndecoded one at a time without buffering the response body, and collected per page; otherwise\nthe pages are read as usual")
+	flag.DurationVar(&fetchBudget, "fetch-budget", 0, "time limit for fetching all pages, after which the feed is written with the news collected so far;\n0 for no limit")
+	flag.IntVar(&retryBudget, "retry-budget", 0, "maximum number of request retries for the whole run, after which the next failure that\nwould be retried fails the run; 0 for no limit")
+	flag.BoolVar(&noSchemaCheck, "no-schema-check", false, "do not check the first page of the API response for signs of a changed schema")
+	flag.IntVar(&maxItemBytes, "max-item-bytes", 0, "maximum size of an <item> element in bytes of the output encoding, 0 for no limit")
+	flag.StringVar(&oversized, "oversized-items", "truncate", "action for items over the size limit, one of: truncate (the description), skip")
+	flag.StringVar(&guidScheme, "guid-scheme", "id", "scheme of item GUIDs, one of: id (the numeric ID), tag (tag URI made of the link path,\nstable across changes of the ID)")
+	flag.StringVar(&dedupBy, "dedup-by", "id", "comma-separated list of keys to detect duplicate news items by, from: id, url;\nduplicates by id are always detected, because each item must have a unique GUID")
+	flag.IntVar(&perCategoryLimit, "per-category-limit", 0, "maximum number of news items from each site section, as in the first element of the\nURL path; 0 for no limit")
+	flag.IntVar(&warnBelow, "warn-below", 0, "exit with code "+strconv.Itoa(exitWarnBelow)+" if fewer news items than the given number are emitted; 0 to disable")
+	flag.IntVar(&failBelow, "fail-below", 0, "exit with code "+strconv.Itoa(exitFailBelow)+" if fewer news items than the given number are emitted; 0 to disable")
+	flag.IntVar(&maxPages, "max-pages", 50, "maximum number of pages to read, regardless of the number of news items emitted")
+	flag.IntVar(&copyrightYear, "copyright-year", 0, "year in the copyright notice, 0 for the current year")
+	flag.StringVar(&iconURL, "icon-url", "", "URL of the feed icon for the <webfeeds:icon> channel element, omitted if empty")
+	flag.StringVar(&xmlBase, "xml-base", "", "absolute URL for the xml:base attribute of the <rss> element, to resolve relative links in the content")
+	flag.StringVar(&readMore, "read-more", "", "label of the link to the article to append to each description, e.g., \"Читать далее\";\nthe link is kept when the description is truncated, and omitted if the label is empty")
+	flag.BoolVar(&embedProvenance, "embed-provenance", false, "write a comment with the program version, the time, and the given flags (with credentials\nredacted) after the XML declaration")
+	flag.StringVar(&imageDescription, "image-description", "", "description of the channel image, omitted if empty")
+	flag.StringVar(&blocklistFile, "blocklist-file", "", "file with IDs of the news items to skip, one per line; comments start with \"#\"")
+	flag.StringVar(&urlPattern, "url-regex", "", "regular expression for the URL paths of the news items to keep, e.g., ^/video/;\nthe paths are as received from the API, without the server name")
+	flag.BoolVar(&stripQuery, "strip-query", false, "remove the query string from the links of the news items")
+	flag.Var(rewriteList{}, "rewrite-link", `substitution in the links of the news items, in the form "old=new"; may be repeated,
+the substitutions are applied in order, after -strip-query`)
+	flag.IntVar(&maxTitle, "max-title", 0, "maximum length of item titles in characters, including the ellipsis that replaces the cut\ntext, but not -title-prefix or -title-suffix; 0 for no limit")
+	flag.StringVar(&titlePrefix, "title-prefix", "", "text to prepend to the title of each news item")
+	flag.StringVar(&titleSuffix, "title-suffix", "", "text to append to the title of each news item")
+	flag.BoolVar(&skipBadPages, "skip-bad-pages", false, "skip pages that cannot be de-serialised, instead of aborting")
+	flag.StringVar(&tz, "tz", "UTC", "IANA name of the time zone for item timestamps in the output, e.g., Europe/Moscow")
+	flag.IntVar(&maxOutputBytes, "max-output-bytes", 0, "maximum size of the output document in bytes, 0 for no limit; items that do not fit are dropped")
+	flag.StringVar(&newerThanFeed, "newer-than-feed", "", "emit only the news items newer than the newest item in the given RSS file, e.g., the\nprevious output; a missing file means all the items are new")
+	flag.StringVar(&outputPath, "output", "", "write the feed to the given file instead of STDOUT")
+	flag.StringVar(&statusFile, "status-file", "", "write the result of the run as JSON to the given file upon exit, regardless of success or failure")
+	flag.StringVar(&changelogFile, "changelog-file", "", "write the list of news items added to and removed from the output file to the given file,\none per line, as \"+\" or \"-\", the GUID, and the title")
+	flag.BoolVar(&writeHash, "write-hash", false, "write the hash of the item set to the file with the name of the output file plus \".etag\",\nand do not update the output file if the hash is unchanged")
+	flag.StringVar(&outputMode, "output-mode", "replace", "mode of writing the output file, one of: replace, append (add new items to the existing ones)")
+	flag.StringVar(&outputFIFO, "output-fifo", "", "write the feed to the given named pipe instead of STDOUT; exit code "+strconv.Itoa(exitBrokenPipe)+" means the reader has closed the pipe early")
+	flag.Uint64Var(&seed, "seed", 0, "seed for the random number generator, 0 for a time-based seed")
+	flag.BoolVar(&clampFuture, "clamp-future", false, "replace timestamps more than "+futureTolerance.String()+" in the future with the current time")
+	flag.BoolVar(&dedupeTitle, "dedupe-title", false, "skip news items with the same title as one of the items before, ignoring letter case,\npunctuation, and whitespace")
+	flag.BoolVar(&stableOrder, "stable-order", false, "sort the news items by timestamp, newest first, and then by ID, so that the same items\nalways produce the same output; the items are held in memory until all pages are read")
+	flag.BoolVar(&listSectionsOnly, "list-sections", false, "print the site sections observed in the news item URLs from the first page, with\nthe number of items in each, and exit")
+	flag.IntVar(&previewItems, "preview", 0, "print the given number of news items that pass the filters as \"[HH:MM] Title — link\" lines,\nand exit without writing a feed; 0 to disable")
+	flag.BoolVar(&countOnly, "count-only", false, "read all pages up to -max-pages, print the number of unique news items that pass the filters,\nand exit without writing a feed")
+	flag.BoolVar(&estimateOnly, "estimate", false, "fetch the first page only, print the estimated number of requests and bytes to download\n(uncompressed) for -num-items, and exit")
+	flag.BoolVar(&warmupOnly, "warmup", false, "only check that the API is available and its first page looks valid, without writing a feed")
+	flag.BoolVar(&headerOnly, "header-only", false, "write the channel header and the closing tags only, without fetching any news")
+	flag.BoolVar(&uaDetail, "ua-detail", false, "add OS and Go version to the User-Agent HTTP header")
+	flag.BoolVar(&compactOutput, "compact", false, "remove all whitespace between XML elements in the output")
+	flag.StringVar(&feedFormat, "format", "rss", "output format, one of: rss (RSS 2.0), rdf (RSS 1.0)")
+	flag.StringVar(&encoding, "encoding", "utf-8", "character encoding of the output, one of: utf-8, windows-1251; characters not representable\nin the encoding are written as numeric character references")
+	flag.StringVar(&lineEnding, "line-ending", "lf", "line ending in the output, one of: lf, crlf")
+	flag.StringVar(&templateFile, "template", "", "Go text/template file with \"header\", \"item\", and \"footer\" templates to render the output with;\nthe template controls the layout fully, so -compact, -item-order, and -line-ending have no effect, and\noversized items are always skipped")
+	flag.BoolVar(&printTemplate, "print-template", false, "write the default output template to STDOUT and exit")
+	flag.StringVar(&emitSyslog, "emit-syslog", "", "also send each emitted news item as an event to the local syslog daemon, with the given\nfacility: user, daemon, or local0 to local7; empty to disable")
+	flag.StringVar(&filterCmd, "filter-cmd", "", "shell command to filter news items: it receives each item as JSON on STDIN, and the item\nis kept only if the command exits with code 0; a JSON object on its STDOUT replaces the item")
+	flag.StringVar(&validateLinks, "validate-links", "", "check item links with HEAD requests, and either drop or warn about items with dead links;\none of: drop, warn, or empty to skip the check")
+	flag.StringVar(&itemOrder, "item-order", "title,description,link,guid,pubDate", "comma-separated order of <item> child elements; all the elements must be listed")
+	flag.StringVar(&basicUser, "basic-user", "", "user name for HTTP basic authentication with the API server")
+	flag.StringVar(&basicPass, "basic-pass", "", "password for HTTP basic authentication with the API server; if not given, it is taken\nfrom the environment variable "+basicPassEnv)
+	flag.Var(headerList{}, "header", `extra HTTP header for every request to the API server, in the form "Name: Value"; may be repeated;
+the headers are not sent with -validate-links requests to other hosts`)
+
+	flag.Usage = usage
+
+	args, err := expandArgs(os.Args[1:])
+
+	if err != nil {
+		return
+	}
+
+	flag.CommandLine.Parse(args)
+
+	if flag.NArg() > 0 {
+		err = errors.New("unexpected argument: " + strconv.Quote(flag.Arg(0)))
+		return
+	}
+
+	// run result
+	if len(statusFile) > 0 {
+		writeStatusAtExit(start)
+	}
+
+	// validate and apply flags
+	if err = app.SetLogLevel(logLevel); err != nil {
+		return
+	}
+
+	if numItems < 1 || numItems > 500 {
+		return errors.New("invalid number of items: " + strconv.Itoa(numItems))
+	}
+
+	if outputTZ, err = time.LoadLocation(tz); err != nil {
+		return failure("invalid time zone", err)
+	}
+
+	if maxPages < 1 {
+		return errors.New("invalid number of pages: " + strconv.Itoa(maxPages))
+	}
+
+	if warnBelow < 0 || failBelow < 0 {
+		return errors.New("item count thresholds cannot be negative")
+	}
+
+	if previewItems < 0 {
+		return errors.New("invalid number of items to preview: " + strconv.Itoa(previewItems))
+	}
+
+	if perCategoryLimit < 0 {
+		return errors.New("invalid per-category limit: " + strconv.Itoa(perCategoryLimit))
+	}
+
+	if httpTimeout <= 0 {
+		return errors.New("invalid HTTP timeout: " + httpTimeout.String())
+	}
+
+	if dialTimeout < 0 || tlsTimeout < 0 || pageDeadline < 0 || fetchBudget < 0 || stallTimeout < 0 || startJitter < 0 {
+		return errors.New("timeouts cannot be negative")
+	}
+
+	if retryBudget < 0 {
+		return errors.New("invalid retry budget: " + strconv.Itoa(retryBudget))
+	}
+
+	if maxTitle < 0 {
+		return errors.New("invalid title length limit: " + strconv.Itoa(maxTitle))
+	}
+
+	if maxItemBytes < 0 {
+		return errors.New("invalid item size limit: " + strconv.Itoa(maxItemBytes))
+	}
+
+	if maxOutputBytes < 0 {
+		return errors.New("invalid output size limit: " + strconv.Itoa(maxOutputBytes))
+	}
+
+	if copyrightYear < 0 || copyrightYear > 9999 {
+		return errors.New("invalid copyright year: " + strconv.Itoa(copyrightYear))
+	}
+
+	if len(xmlBase) > 0 {
+		if u, e := url.Parse(xmlBase); e != nil || !u.IsAbs() || len(u.Host) == 0 {
+			return errors.New("invalid xml:base URL: " + strconv.Quote(xmlBase))
+		}
+	}
+
+	if len(iconURL) > 0 {
+		if u, e := url.Parse(iconURL); e != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return errors.New("invalid icon URL: " + strconv.Quote(iconURL))
+		}
+	}
+
+	switch feedFormat {
+	case "rss":
+	case "rdf":
+		flag.Visit(func(f *flag.Flag) {
+			if err == nil && slices.Contains(rdfUnsupported, f.Name) {
+				err = errors.New("flag -" + f.Name + " cannot be used with rdf output format")
+			}
+		})
+
+		if err != nil {
+			return
+		}
+	default:
+		return errors.New("invalid output format: " + strconv.Quote(feedFormat))
+	}
+
+	if len(blocklistFile) > 0 {
+		if err = readBlocklist(blocklistFile); err != nil {
+			return
+		}
+	}
+
+	if len(urlPattern) > 0 {
+		if urlRegex, err = regexp.Compile(urlPattern); err != nil {
+			return failure("invalid URL pattern", err)
+		}
+	}
+
+	if err = setEncoding(encoding); err != nil {
+		return
+	}
+
+	if err = setItemOrder(itemOrder); err != nil {
+		return
+	}
+
+	if err = setLineEnding(lineEnding); err != nil {
+		return
+	}
+
+	if outputMode != "replace" && outputMode != "append" {
+		return errors.New("invalid output mode: " + strconv.Quote(outputMode))
+	}
+
+	if outputMode == "append" && len(outputPath) == 0 {
+		return errors.New("append output mode requires an output file")
+	}
+
+	if len(changelogFile) > 0 && len(outputPath) == 0 {
+		return errors.New("writing the changelog requires an output file")
+	}
+
+	if writeHash && len(outputPath) == 0 {
+		return errors.New("writing the hash requires an output file")
+	}
+
+	if outputMode == "append" && maxOutputBytes > 0 {
+		return errors.New("output size limit is not supported in append output mode")
+	}
+
+	if len(outputPath) > 0 && len(outputFIFO) > 0 {
+		return errors.New("output file and output FIFO cannot be used together")
+	}
+
+	if len(basicPass) == 0 {
+		basicPass = os.Getenv(basicPassEnv)
+	}
+
+	if (len(basicUser) > 0) != (len(basicPass) > 0) {
+		return errors.New("basic authentication requires both user name and password")
+	}
+
+	if validateLinks != "" && validateLinks != "drop" && validateLinks != "warn" {
+		return errors.New("invalid action for dead links: " + strconv.Quote(validateLinks))
+	}
+
+	if guidScheme != "id" && guidScheme != "tag" {
+		return errors.New("invalid GUID scheme: " + strconv.Quote(guidScheme))
+	}
+
+	if oversized != "truncate" && oversized != "skip" {
+		return errors.New("invalid action for oversized items: " + strconv.Quote(oversized))
+	}
+
+	for _, key := range splitList(dedupBy) {
+		switch key {
+		case "id":
+			// always on
+		case "url":
+			dedupByURL = true
+		default:
+			return errors.New("invalid duplicate detection key: " + strconv.Quote(key))
+		}
+	}
+
+	if uaDetail {
+		userAgent += " (" + runtime.GOOS + "; " + runtime.Version() + ")"
+	}
+
+	for _, name := range [...]string{"Accept", "User-Agent"} {
+		if _, yes := extraHeaders[name]; yes {
+			app.Warn("overriding the default value of HTTP header %q", name)
+		}
+	}
+
+	if _, yes := extraHeaders["Authorization"]; yes && len(basicUser) > 0 {
+		app.Warn("HTTP header \"Authorization\" is overridden by basic authentication")
+	}
+
+	if compactOutput {
+		setCompact()
+	}
+
+	if len(templateFile) > 0 {
+		if outputMode == "append" {
+			return errors.New("append output mode cannot be used with a template")
+		}
+
+		if err = loadTemplate(templateFile); err != nil {
+			return
+		}
+	}
+
+	logConfig()
+
+	// random number generator
+	if seed == 0 {
+		seed = uint64(app.Now().UnixNano())
+	}
+
+	app.Info("random seed: %d", seed)
+
+	rng = rand.New(rand.NewPCG(seed, seed))
+
+	// default template
+	if printTemplate {
+		if _, err = io.WriteString(os.Stdout, defaultTemplate); err != nil {
+			err = failure("writing output", err)
+		}
+
+		return
+	}
+
+	// memory limit
+	if memLimit > 0 {
+		watchMemory()
+	}
+
+	// API check
+	client := newHTTPClient()
+
+	if warmupOnly {
+		return warmup(client)
+	}
+
+	if listSectionsOnly {
+		return listSections(client)
+	}
+
+	if estimateOnly {
+		return estimate(client, numItems)
+	}
+
+	// filters after the source stage
+	dropsAfterSource = maxItemBytes > 0 || urlRegex != nil || len(blocked) > 0 || dedupeTitle ||
+		perCategoryLimit > 0 || len(newerThanFeed) > 0 || len(filterCmd) > 0 || validateLinks == "drop"
+
+	// XML header
+	header, err := makeHeader(skipHours, skipDays)
+
+	if err != nil {
+		return
+	}
+
+	// syslog events
+	if len(emitSyslog) > 0 && !countOnly {
+		if err = openItemSyslog(emitSyslog); err != nil {
+			return
+		}
+	}
+
+	// news items
+	src := pump.Bind(source(numItems, client), convert)
+
+	if len(newerThanFeed) > 0 {
+		newest, err := readNewestTS(newerThanFeed)
+
+		if err != nil {
+			return err
+		}
+
+		if !newest.IsZero() {
+			src = pump.Bind(src, skipNotNewer(newest))
+		}
+	}
+
+	if len(filterCmd) > 0 {
+		src = pump.Bind(src, filterByCmd)
+	}
+
+	if len(validateLinks) > 0 {
+		src = pump.Bind(src, linkValidator(client))
+	}
+
+	if dedupeTitle {
+		src = pump.Bind(src, dedupeTitles(numItems))
+	}
+
+	if perCategoryLimit > 0 {
+		src = pump.Bind(src, limitPerCategory(perCategoryLimit))
+	}
+
+	if stableOrder {
+		src = pump.Bind(src, sortItems)
+	}
+
+	if countOnly {
+		readAllPages = true
+		return countItems(src)
+	}
+
+	if previewItems > 0 {
+		return preview(src, previewItems)
+	}
+
+	if headerOnly {
+		src = pump.FromSlice([]*NewsItem(nil))
+	} else {
+		// check the number of items emitted, once the feed is successfully written out
+		defer func() {
+			if err == nil && !app.Failed() {
+				checkEmitted(warnBelow, failBelow)
+			}
+		}()
+	}
+
+	if len(outputPath) > 0 {
+		return writeOutputFile(header, src, numItems)
+	}
+
+	// output
+	out := io.Writer(os.Stdout)
+
+	if len(outputFIFO) > 0 {
+		var fifo *os.File
+
+		if fifo, err = openFIFO(outputFIFO); err != nil {
+			return
+		}
+
+		defer fifo.Close()
+
+		out = fifo
+	} else {
+		// by default, Go runtime terminates the program on a broken pipe on STDOUT,
+		// but with the signal ignored the write returns EPIPE
+		signal.Ignore(syscall.SIGPIPE)
+	}
+
+	// read the news and write out XML
+	err = writeFeed(out, header, src)
+
+	if errors.Is(err, syscall.EPIPE) {
+		if len(outputFIFO) > 0 {
+			app.ErrorCode(exitBrokenPipe, "FIFO reader has gone away: %s", err)
+		} else {
+			// the reader has got all it wanted, like "vesti-rss | head"
+			app.Info("STDOUT closed by the reader: %s", err)
+		}
+
+		err = nil
+	}
+
+	return
+}
+
+// exit codes for broken output pipe, and for the number of items emitted below the thresholds
+const (
+	exitBrokenPipe = 3
+	exitWarnBelow  = 4
+	exitFailBelow  = 5
+)
+
+// set exit code if the number of news items emitted is below any of the given thresholds
+func checkEmitted(warnBelow, failBelow int) {
+	switch {
+	case stats.emitted < failBelow:
+		app.ErrorCode(exitFailBelow, "only %d news items emitted, expected at least %d", stats.emitted, failBelow)
+	case stats.emitted < warnBelow:
+		app.WarnCode(exitWarnBelow, "only %d news items emitted, expected at least %d", stats.emitted, warnBelow)
+	}
+}
+
+// open the named pipe for writing; the call waits until the other end is opened for reading, or the
+// application is shutting down.
+// There is no need to handle EINTR or partial writes, because (*os.File).Write() retries in both cases.
+func openFIFO(path string) (*os.File, error) {
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return nil, failure("output FIFO", err)
+	}
+
+	if info.Mode()&os.ModeNamedPipe == 0 {
+		return nil, errors.New("output FIFO: not a named pipe: " + strconv.Quote(path))
+	}
+
+	app.Info("waiting for a reader to open FIFO %q", path)
+
+	// a non-blocking open of the write end fails with ENXIO until there is a reader, so keep
+	// retrying to stay responsive to shutdown; once opened, the file is handled by the Go
+	// runtime poller, and writes wait for the reader as usual
+	ticker := time.NewTicker(100 * time.Millisecond)
+
+	defer ticker.Stop()
+
+	for {
+		fifo, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+
+		if err == nil {
+			return fifo, nil
+		}
+
+		if !errors.Is(err, syscall.ENXIO) {
+			return nil, failure("output FIFO", err)
+		}
+
+		select {
+		case <-app.Shut():
+			return nil, app.Context().Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// print usage message
+func usage() {
+	out := flag.CommandLine.Output()
+
+	fmt.Fprintf(out, "Usage: %s [flags]\n", filepath.Base(os.Args[0]))
+	fmt.Fprint(out, "Flags can also be read from a file specified as @file (or @- for STDIN), with one flag\n"+
+		"per line in the form \"-name=value\" or \"-name value\", and comments starting with \"#\".\n"+
+		"Only the arguments in place of a flag are expanded, so \"-name @x\" passes \"@x\" as the value;\n"+
+		"use \"-name=@x\" to make sure a value starting with \"@\" is never taken for a file.\n")
+	flag.PrintDefaults()
+}
+
+// log the effective configuration, in a stable order
+func logConfig() {
+	msg := "configuration: server=" + strconv.Quote(server)
+
+	flag.VisitAll(func(f *flag.Flag) {
+		msg += " " + f.Name + "=" + strconv.Quote(flagValue(f))
+	})
+
+	app.Info(msg)
+}
+
+// value of the given flag for logging, with credentials redacted; HTTP headers are shown
+// by name only, see headerList
+func flagValue(f *flag.Flag) string {
+	value := f.Value.String()
+
+	if f.Name == "basic-pass" && len(value) > 0 {
+		value = "<redacted>"
+	}
+
+	return value
+}
+
+// expand each @file argument in place of a flag into the flags from that file; the expansion
+// is not recursive. The values of the flags, and the arguments after the first non-flag one
+// are never expanded.
+func expandArgs(args []string) ([]string, error) {
+	res := make([]string, 0, len(args))
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		if arg == "--" {
+			return append(res, args[i:]...), nil
+		}
+
+		if len(arg) < 2 || arg[0] != '@' {
+			res = append(res, arg)
+
+			switch {
+			case takesValue(arg) && i+1 < len(args):
+				// the value of the flag
+				i++
+				res = append(res, args[i])
+			case len(arg) < 2 || arg[0] != '-':
+				// flag parsing stops at the first non-flag argument
+				return append(res, args[i+1:]...), nil
+			}
+
+			continue
+		}
+
+		var (
+			data []byte
+			err  error
+		)
+
+		if arg == "@-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(arg[1:])
+		}
+
+		if err != nil {
+			return nil, failure("reading arguments", err)
+		}
+
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); len(line) == 0 || line[0] == '#' {
+				continue
+			}
+
+			if line[0] != '-' {
+				return nil, errors.New("invalid line in arguments file " + strconv.Quote(arg[1:]) + ": " + strconv.Quote(line))
+			}
+
+			// the value is taken verbatim till the end of the line, without any quoting
+			j := strings.IndexAny(line, "= \t")
+
+			switch {
+			case j < 0 || line[j] == '=':
+				res = append(res, line)
+			case takesValue(line[:j]):
+				res = append(res, line[:j], strings.TrimSpace(line[j:]))
+			default:
+				// boolean or unknown flag
+				res = append(res, line[:j]+"="+strings.TrimSpace(line[j:]))
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// check if the given argument is a flag without "=" that takes its value from the next argument
+func takesValue(arg string) bool {
+	name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+
+	if len(name) == len(arg) || strings.Contains(name, "=") {
+		return false
+	}
+
+	f := flag.Lookup(name)
+
+	if f == nil {
+		return false
+	}
+
+	b, isBool := f.Value.(interface{ IsBoolFlag() bool })
+
+	return !isBool || !b.IsBoolFlag()
+}
+
+// raw news item
+type RawNewsItem struct {
+	ID                uint64
+	Title, Anons, URL string
+
+	DatePub struct {
+		Day, Time string
+	}
+}
+
+// news item
+type NewsItem struct {
+	id                uint64
+	title, text, link string
+	guid              string
+	ts                time.Time
+}
+
+// random number generator, for all randomised behaviour
+var rng *rand.Rand
+
+// HTTP timeouts
+var httpTimeout, dialTimeout, tlsTimeout time.Duration
+
+// enables detection of duplicates by URL, in addition to ID
+var dedupByURL bool
+
+// maximum number of pages to read
+var maxPages int
+
+// deadline for fetching and processing each page
+var pageDeadline time.Duration
+
+// credentials for HTTP basic authentication with the API server
+var basicUser, basicPass string
+
+// environment variable with the password for HTTP basic authentication
+const basicPassEnv = "VESTI_BASIC_PASS"
+
+// time limit for receiving the next portion of a response body
+var stallTimeout time.Duration
+
+// enables streaming de-serialisation of the pages in NDJSON format, if the server offers it
+var streamInput bool
+
+// maximum random delay before the first request
+var startJitter time.Duration
+
+// heap size limit in MiB, and the flag indicating that the limit has been reached
+var (
+	memLimit    uint
+	memLimitHit atomic.Bool
+)
+
+// start a goroutine that watches the heap size, and stops reading pages once the limit is reached
+func watchMemory() {
+	app.Go(func() error {
+		ticker := time.NewTicker(100 * time.Millisecond)
+
+		defer ticker.Stop()
+
+		var ms runtime.MemStats
+
+		for {
+			select {
+			case <-app.Shut():
+				return nil
+			case <-ticker.C:
+				if runtime.ReadMemStats(&ms); ms.HeapAlloc > uint64(memLimit)<<20 {
+					app.Warn("heap size of %d MiB is over the limit of %d MiB", ms.HeapAlloc>>20, memLimit)
+					memLimitHit.Store(true)
+					return nil
+				}
+			}
+		}
+	})
+}
+
+// time limit for fetching all pages, after which the output is written with the news collected so far
+var fetchBudget time.Duration
+
+// enables skipping pages that cannot be de-serialised
+var skipBadPages bool
+
+// run statistics
+var stats struct {
+	pages   int // number of pages read
+	fetched int // number of unique news items read
+	emitted int // number of news items written out
+	present int // number of news items already present in the output file, or in the -newer-than-feed file
+	badURL  int // number of news items skipped because of an invalid URL
+	badTS   int // number of news items skipped because of an invalid timestamp
+	skipped int // total number of news items skipped for any reason other than duplication
+	dups    int // number of duplicate news items skipped
+	held    int // number of news items held for sorting
+	retries int // number of requests retried
+
+	newest, oldest time.Time // timestamps of the newest and the oldest news items emitted
+}
+
+// URL of the first page of the news
+const firstPage = server + "/api/news"
+
+// estimated number of news items per page
+const pageSize = 20
+
+// Initial capacity of the sets used to detect duplicates. Normally, the number of items read is just
+// the requested number rounded up to the page size, but when items can be dropped after the source
+// stage more pages are likely to be read, so the estimate is doubled, though never beyond the page limit.
+func seenCapacity(numItems int) int {
+	n := numItems + pageSize
+
+	if dropsAfterSource {
+		n *= 2
+	}
+
+	return min(n, maxPages*pageSize)
+}
+
+// set if any of the stages after the source can drop news items
+var dropsAfterSource bool
+
+// make HTTP client; the client makes one connection at a time, and does not follow redirects
+func newHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: httpTimeout,
+		Transport: &http.Transport{
+			DialContext:         (&net.Dialer{Timeout: dialTimeout}).DialContext,
+			TLSHandshakeTimeout: tlsTimeout,
+			MaxIdleConns:        1,
+			MaxConnsPerHost:     1,
+			IdleConnTimeout:     20 * time.Second,
+		},
+		CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// news reader source (generator constructor)
+func source(numItems int, client *http.Client) pump.Gen[*RawNewsItem] {
+	return func(yield func(*RawNewsItem) error) error {
+		// response receiver
+		var batch struct {
+			Success bool
+			Data    []RawNewsItem
+
+			Pagination struct {
+				Next string
+			}
+		}
+
+		// first page URL
+		batch.Pagination.Next = firstPage
+
+		// a set to detect duplicates
+		seen := make(map[uint64]struct{}, seenCapacity(numItems))
+
+		// URL to ID mapping to detect republished articles
+		var seenURLs map[string]uint64
+
+		if dedupByURL {
+			seenURLs = make(map[string]uint64, seenCapacity(numItems))
+		}
+
+		// yield news item, unless it is a duplicate
+		take := func(ctx context.Context, item *RawNewsItem) error {
+			// check for duplicate
+			if _, yes := seen[item.ID]; yes {
+				app.Warn("skipped a duplicate of the news item %d (by id)", item.ID)
+				stats.dups++
+				return nil
+			}
+
+			if id, yes := seenURLs[item.URL]; yes && dedupByURL {
+				app.Warn("skipped news item %d as a duplicate of the news item %d (by url)", item.ID, id)
+				stats.dups++
+				return nil
+			}
+
+			// yield
+			if err := yield(item); err != nil {
+				return err
+			}
+
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			// mark as seen
+			seen[item.ID] = struct{}{}
+			stats.fetched++
+
+			if dedupByURL {
+				seenURLs[item.URL] = item.ID
+			}
+
+			return nil
+		}
+
+		// check if we've got enough news; the count is of the items actually written out,
+		// so that the items skipped at any later stage do not take up the quota
+		enough := func() bool {
+			if readAllPages {
+				return false
+			}
+
+			if stats.emitted+stats.present+stats.held >= numItems {
+				app.Info("processed %d news items from %d pages, %d news items emitted.", stats.fetched, stats.pages, stats.emitted+stats.held)
+				return true
+			}
+
+			return false
+		}
+
+		// streaming page reader, for NDJSON responses
+		readStream := func(pageURL string, body io.Reader) ([]RawNewsItem, int64, error) {
+			dec := json.NewDecoder(body)
+			items := make([]RawNewsItem, 0, pageSize)
+
+			for {
+				var item RawNewsItem
+
+				if err := dec.Decode(&item); err != nil {
+					if err == io.EOF {
+						break
+					}
+
+					if !skipBadPages {
+						return nil, 0, failure("invalid response", err)
+					}
+
+					app.Warn("skipped the rest of a malformed page from %s: %s", pageURL, err)
+					break
+				}
+
+				items = append(items, item)
+			}
+
+			return items, dec.InputOffset(), nil
+		}
+
+		// yield the news items from a streamed page, after the response body is closed, so that
+		// the later pipeline stages do not hold the connection; returns true when no more pages
+		// need to be read
+		takeStream := func(ctx context.Context, pageURL string, items []RawNewsItem, size int64, start time.Time) (bool, error) {
+			app.InfoKV("page read", "page", stats.pages+1, "url", pageURL, "status", http.StatusOK,
+				"bytes", size, "items", len(items), "duration_ms", time.Since(start).Milliseconds())
+
+			if len(items) == 0 {
+				if stats.pages == 0 {
+					return false, errors.New("response contains no news")
+				}
+
+				app.Info("no more news after %d pages", stats.pages)
+				return true, nil
+			}
+
+			// check the first page for signs of schema change
+			if stats.pages == 0 {
+				if err := checkSchema(items); err != nil {
+					return false, err
+				}
+			}
+
+			for i := range items {
+				if err := take(ctx, &items[i]); err != nil {
+					return false, err
+				}
+			}
+
+			stats.pages++
+
+			// the stream has no pagination data, so the next page URL is guessed
+			if batch.Pagination.Next, _ = makeURL(guessNextPage(pageURL)); len(batch.Pagination.Next) == 0 {
+				app.Warn("stopped at a page without a next page: %s", pageURL)
+				return true, nil
+			}
+
+			return enough(), nil
+		}
+
+		// page reader; returns true when no more pages need to be read
+		readPage := func(ctx context.Context) (bool, error) {
+			pageURL := batch.Pagination.Next
+
+			app.Info("reading page from " + pageURL)
+
+			// make request
+			start := time.Now()
+			accept := "application/json"
+
+			if streamInput {
+				accept = "application/x-ndjson, application/json;q=0.9"
+			}
+
+			var (
+				body     []byte
+				stream   []RawNewsItem
+				size     int64
+				streamed bool
+			)
+
+			err := retryTransient(ctx, pageURL, func() error {
+				resp, err := openResponse(ctx, pageURL, accept, client)
+
+				if err != nil {
+					return err
+				}
+
+				defer resp.Body.Close()
+
+				if streamed = streamInput && isNDJSON(resp.Header.Get("Content-Type")); streamed {
+					stream, size, err = readStream(pageURL, resp.Body)
+					return err
+				}
+
+				body, err = readResponse(resp.Body)
+				return err
+			})
+
+			if err != nil {
+				return false, err
+			}
+
+			if streamed {
+				return takeStream(ctx, pageURL, stream, size, start)
+			}
+
+			app.InfoKV("page read", "page", stats.pages+1, "url", pageURL, "status", http.StatusOK,
+				"bytes", len(body), "duration_ms", time.Since(start).Milliseconds())
+
+			// de-serialise response body
+			batch.Success = false
+			batch.Data = batch.Data[:0]
+			batch.Pagination.Next = ""
+
+			if err = json.Unmarshal(body, &batch); err != nil {
+				if !skipBadPages {
+					return false, failure("invalid response", err)
+				}
+
+				// try to carry on from the next page, if its URL can be found
+				next := batch.Pagination.Next
+
+				if len(next) == 0 {
+					next = guessNextPage(pageURL)
+				}
+
+				if batch.Pagination.Next, _ = makeURL(next); len(batch.Pagination.Next) == 0 {
+					app.Warn("stopped at a malformed page with %d news items emitted: %s", stats.emitted, err)
+					return true, nil
+				}
+
+				app.Warn("skipped a malformed page from %s: %s", pageURL, err)
+				stats.pages++
+				return false, nil
+			}
+
+			// validate the response
+			if !batch.Success {
+				return false, errors.New("response indicates an error")
+			}
+
+			if len(batch.Data) == 0 {
+				return false, errors.New("response contains no news")
+			}
+
+			// check the first page for signs of schema change
+			if stats.pages == 0 {
+				if err := checkSchema(batch.Data); err != nil {
+					return false, err
+				}
+			}
+
+			// next page URL; the last page has none
+			last := len(batch.Pagination.Next) == 0
+
+			if !last {
+				if batch.Pagination.Next, err = makeURL(batch.Pagination.Next); err != nil {
+					return false, failure("next page URL", err)
+				}
+			}
+
+			// loop over the news batch
+			for i := range batch.Data {
+				if err = take(ctx, &batch.Data[i]); err != nil {
+					return false, err
+				}
+			}
+
+			stats.pages++
+
+			if last {
+				app.Info("no more news after %d pages", stats.pages)
+				return true, nil
+			}
+
+			return enough(), nil
+		}
+
+		// random delay before the first request
+		if startJitter > 0 {
+			delay := time.Duration(rng.Int64N(int64(startJitter) + 1))
+
+			app.Info("start delayed by %s", delay)
+
+			select {
+			case <-app.Shut():
+				return app.Context().Err()
+			case <-time.After(delay):
+			}
+		}
+
+		// end of the fetch budget
+		var budgetEnd time.Time
+
+		if fetchBudget > 0 {
+			budgetEnd = app.Now().Add(fetchBudget)
+		}
+
+		// batch reader loop
+		for {
+			// check page limit
+			if stats.pages == maxPages {
+				app.Warn("stopped after reading %d pages, with %d news items emitted", stats.pages, stats.emitted)
+				return nil
+			}
+
+			// check memory limit
+			if memLimitHit.Load() {
+				app.Warn("stopped because of the memory limit, with %d pages read, and %d news items emitted",
+					stats.pages, stats.emitted)
+				return nil
+			}
+
+			// check fetch budget
+			if fetchBudget > 0 && app.Now().After(budgetEnd) {
+				app.Warn("stopped after the fetch budget of %s with %d pages read, and %d news items emitted",
+					fetchBudget, stats.pages, stats.emitted)
+				return nil
+			}
+
+			// read page
+			pageURL := batch.Pagination.Next
+
+			if err := checkPageHost(pageURL); err != nil {
+				return err
+			}
+
+			ctx, cancel := pageContext()
+			done, err := readPage(ctx)
+
+			if err != nil && ctx.Err() == context.DeadlineExceeded && app.Running() {
+				err = errors.New("page deadline of " + pageDeadline.String() + " exceeded on page " + pageURL)
+			}
+
+			cancel()
+
+			if err != nil || done {
+				return err
+			}
+		}
+	}
+}
+
+// check that the page URL points to the API server, so that a buggy or malicious response
+// cannot make the reader follow links to other hosts
+func checkPageHost(pageURL string) error {
+	if u, err := url.Parse(pageURL); err != nil || !isServerURL(u) {
+		return errors.New("refusing to follow page URL to a host other than the API server: " + strconv.Quote(pageURL))
+	}
+
+	return nil
+}
+
+// check if the given URL points to the API server
+func isServerURL(u *url.URL) bool {
+	api, _ := url.Parse(server)
+
+	return u.Scheme == api.Scheme && strings.EqualFold(u.Host, api.Host)
+}
+
+// makes the source read all the pages up to -max-pages, regardless of -num-items
+var readAllPages bool
+
+// disables API schema check
+var noSchemaCheck bool
+
+// make context for reading a page
+func pageContext() (context.Context, context.CancelFunc) {
+	if pageDeadline > 0 {
+		return context.WithTimeout(app.Context(), pageDeadline)
+	}
+
+	return context.WithCancel(app.Context())
+}
+
+// make the path of the page following the given one, for URLs of the form ".../path?page=N",
+// or the first page URL; returns an empty string if the URL is of any other form
+func guessNextPage(pageURL string) string {
+	u, err := url.Parse(pageURL)
+
+	if err != nil {
+		return ""
+	}
+
+	q := u.Query()
+	page := 1
+
+	if pageURL != firstPage {
+		if page, err = strconv.Atoi(q.Get("page")); err != nil || page < 1 {
+			return ""
+		}
+	}
+
+	q.Set("page", strconv.Itoa(page+1))
+	u.RawQuery = q.Encode()
+
+	return u.RequestURI()
+}
+
+// check if at least one of the given news items has a title and a valid URL; used to detect
+// changes in the API schema that would otherwise produce a feed full of blank items
+func schemaLooksValid(items []RawNewsItem) bool {
+	for i := range items {
+		if len(strings.TrimSpace(items[i].Title)) > 0 {
+			if _, err := makeURL(items[i].URL); err == nil {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// check the news items from the first page for signs of schema change, unless disabled
+func checkSchema(items []RawNewsItem) error {
+	if !noSchemaCheck && !schemaLooksValid(items) {
+		return errors.New("no valid news items on the first page, API schema may have changed")
+	}
+
+	return nil
+}
+
+// check that the API is reachable and its first page looks valid
+func warmup(client *http.Client) error {
+	start := time.Now()
+	items, _, err := readFirstPage(client)
+
+	if err != nil {
+		return failure("API check", err)
+	}
+
+	app.Info("API check passed in %d ms, with %d news items on the first page",
+		time.Since(start).Milliseconds(), len(items))
+
+	return nil
+}
+
+// print the sections observed in the URLs of the news items from the first page, with
+// the number of items in each section
+func listSections(client *http.Client) error {
+	items, _, err := readFirstPage(client)
+
+	if err != nil {
+		return failure("listing sections", err)
+	}
+
+	counts := make(map[string]int)
+
+	for i := range items {
+		if link, err := makeURL(items[i].URL); err == nil {
+			if section := urlSection(link); len(section) > 0 {
+				counts[section]++
+			}
+		}
+	}
+
+	sections := make([]string, 0, len(counts))
+
+	for section := range counts {
+		sections = append(sections, section)
+	}
+
+	sort.Strings(sections)
+
+	var buff []byte
+
+	for _, section := range sections {
+		buff = append(strconv.AppendInt(append(append(buff, section...), '\t'), int64(counts[section]), 10), '\n')
+	}
+
+	if _, err = os.Stdout.Write(buff); err != nil {
+		return failure("writing output", err)
+	}
+
+	return nil
+}
+
+// print the number of news items from the given source
+func countItems(src pump.Gen[*NewsItem]) error {
+	n := 0
+
+	err := src(func(*NewsItem) error {
+		n++
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	app.Info("counted %d news items from %d pages", n, stats.pages)
+
+	if _, err = os.Stdout.Write(append(strconv.AppendInt(nil, int64(n), 10), '\n')); err != nil {
+		return failure("writing output", err)
+	}
+
+	return nil
+}
+
+// print the number of requests and bytes to download for the given number of news items,
+// estimated from the first page
+func estimate(client *http.Client, numItems int) error {
+	items, size, err := readFirstPage(client)
+
+	if err != nil {
+		return failure("estimate", err)
+	}
+
+	pages := min((numItems+len(items)-1)/len(items), maxPages)
+
+	app.Info("first page has %d news items in %d bytes", len(items), size)
+
+	buff := strconv.AppendInt([]byte("requests\t"), int64(pages), 10)
+	buff = strconv.AppendInt(append(buff, "\nbytes\t"...), int64(pages*size), 10)
+
+	if _, err = os.Stdout.Write(append(buff, '\n')); err != nil {
+		return failure("writing output", err)
+	}
+
+	return nil
+}
+
+// print the given number of news items from the source in a human-readable form
+func preview(src pump.Gen[*NewsItem], n int) error {
+	var buff []byte
+
+	err := src(func(news *NewsItem) error {
+		buff = news.ts.In(outputTZ).AppendFormat(append(buff[:0], '['), "15:04")
+		buff = append(append(append(append(buff, "] "...), news.title...), " — "...), news.link...)
+
+		if _, err := os.Stdout.Write(append(buff, '\n')); err != nil {
+			return failure("writing output", err)
+		}
+
+		if n--; n == 0 {
+			return errPreviewDone
+		}
+
+		return nil
+	})
+
+	if err == errPreviewDone {
+		err = nil
 	}
 
-	// read flags
-	var (
-		numItems int
-		logLevel string
-	)
+	return err
+}
 
-	flag.IntVar(&numItems, "num-items", 100, "number of news items to fetch, from 1 to 500; the actual number will be rounded up to the page size")
-	flag.StringVar(&logLevel, "log-level", "error", "logging level, one of: trace, info, warning, error")
+// stops the news source when enough items are printed by preview()
+var errPreviewDone = errors.New("preview done")
 
-	flag.Parse()
+// read and validate the first page of the news; returns the items and the size of the response body
+func readFirstPage(client *http.Client) ([]RawNewsItem, int, error) {
+	ctx, cancel := pageContext()
 
-	// validate and apply flags
-	if err = app.SetLogLevel(logLevel); err != nil {
-		return
+	defer cancel()
+
+	body, err := getResponse(ctx, firstPage, client)
+
+	if err != nil {
+		return nil, 0, err
 	}
 
-	if numItems < 1 || numItems > 500 {
-		return errors.New("invalid number of items: " + strconv.Itoa(numItems))
+	var batch struct {
+		Success bool
+		Data    []RawNewsItem
 	}
 
-	// XML header
-	if err = writeString(xmlHeader); err != nil {
-		return
+	if err = json.Unmarshal(body, &batch); err != nil {
+		return nil, 0, failure("invalid response", err)
 	}
 
-	// buffer
-	buff := append(make([]byte, 0, 4*1024), xmlPrefix...)
+	if !batch.Success {
+		return nil, 0, errors.New("response indicates an error")
+	}
 
-	// read the news and write out XML
-	err = convert(source(numItems), func(news *NewsItem) error {
-		// title
-		buff = append(xmlutil.AppendEscaped(buff[:xmlPrefixLen], news.title), "</title><description>"...)
+	if err = checkSchema(batch.Data); err != nil {
+		return nil, 0, err
+	}
 
-		// description
-		buff = append(xmlutil.AppendEscaped(buff, news.text), "</description><link>"...)
+	return batch.Data, len(body), nil
+}
+
+// section of the site the given link belongs to, i.e., the first element of the URL path
+func urlSection(link string) string {
+	u, err := url.Parse(link)
 
-		// link
-		buff = append(xmlutil.AppendEscaped(buff, news.link), `</link><guid isPermaLink="false">`...)
+	if err != nil {
+		return ""
+	}
 
-		// GUID
-		buff = append(strconv.AppendUint(buff, news.id, 10), "</guid><pubDate>"...)
+	section, _, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	return section
+}
 
-		// timestamp
-		buff = append(news.ts.AppendFormat(buff, time.RFC1123Z), "</pubDate></item>\n"...)
+// title decorations
+var titlePrefix, titleSuffix string
 
-		// write
-		return write(buff)
-	})
+// maximum length of item titles in characters, 0 for no limit
+var maxTitle int
 
-	// XML footer
-	if err == nil {
-		err = writeString("</channel>\n</rss>\n")
+// cut the given title to at most n characters, replacing the tail with an ellipsis
+func truncateTitle(title string, n int) string {
+	if utf8.RuneCountInString(title) <= n {
+		return title
 	}
 
-	return
-}
+	k := 0
 
-// raw news item
-type RawNewsItem struct {
-	ID                uint64
-	Title, Anons, URL string
+	for i := range title {
+		if k == n-utf8.RuneCountInString(ellipsis) {
+			return strings.TrimRightFunc(title[:i], unicode.IsSpace) + ellipsis
+		}
 
-	DatePub struct {
-		Day, Time string
+		k++
 	}
-}
 
-// news item
-type NewsItem struct {
-	id                uint64
-	title, text, link string
-	ts                time.Time
+	return title
 }
 
-// news reader source (generator constructor)
-func source(numItems int) pump.Gen[*RawNewsItem] {
-	return func(yield func(*RawNewsItem) error) error {
-		// HTTP client
-		client := &http.Client{
-			Timeout: 5 * time.Second,
-			Transport: &http.Transport{
-				MaxIdleConns:    1,
-				MaxConnsPerHost: 1,
-				IdleConnTimeout: 20 * time.Second,
-			},
-			CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
-				return http.ErrUseLastResponse
-			},
+// pattern for the URL paths of the news items to keep, nil to keep all
+var urlRegex *regexp.Regexp
+
+// IDs of the news items to skip
+var blocked map[uint64]struct{}
+
+// read IDs of the news items to skip from the given file, one per line; empty lines and
+// comments starting with "#" are ignored
+func readBlocklist(path string) error {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return failure("reading blocklist", err)
+	}
+
+	blocked = make(map[uint64]struct{})
+
+	for i, line := range strings.Split(string(data), "\n") {
+		if j := strings.IndexByte(line, '#'); j >= 0 {
+			line = line[:j]
 		}
 
-		// response receiver
-		var batch struct {
-			Success bool
-			Data    []RawNewsItem
+		if line = strings.TrimSpace(line); len(line) == 0 {
+			continue
+		}
 
-			Pagination struct {
-				Next string
-			}
+		id, err := strconv.ParseUint(line, 10, 64)
+
+		if err != nil {
+			return errors.New("invalid news item ID in blocklist " + strconv.Quote(path) +
+				" at line " + strconv.Itoa(i+1) + ": " + strconv.Quote(line))
 		}
 
-		// first page URL
-		batch.Pagination.Next = server + "/api/news"
+		blocked[id] = struct{}{}
+	}
 
-		// a set to detect duplicates and count items
-		seen := make(map[uint64]struct{}, numItems+20)
+	app.Info("read %d news item IDs from blocklist %s", len(blocked), path)
+	return nil
+}
 
-		// batch reader loop
-		for {
-			app.Info("reading page from " + batch.Pagination.Next)
+// enables replacing future timestamps with the current time
+var clampFuture bool
 
-			// make request
-			body, err := getResponse(batch.Pagination.Next, client)
+// how far in the future a timestamp can be before it is considered invalid
+const futureTolerance = 10 * time.Minute
 
-			if err != nil {
-				return err
-			}
+// convert RawNewsItem to NewsItem (a pipeline stage)
+func convert(src pump.Gen[*RawNewsItem], yield func(*NewsItem) error) error {
+	var (
+		news []NewsItem
+		err  error
+	)
 
-			// de-serialise response body
-			batch.Success = false
-			batch.Data = batch.Data[:0]
-			batch.Pagination.Next = ""
+	// GUIDs of the items produced so far, to catch duplicates from the expander
+	guids := make(map[string]struct{}, 100)
 
-			if err = json.Unmarshal(body, &batch); err != nil {
-				return failure("invalid response", err)
+	return src(func(item *RawNewsItem) error {
+		// blocked items
+		if _, yes := blocked[item.ID]; yes {
+			app.Info("skipped blocked news item %d", item.ID)
+			stats.skipped++
+			return nil
+		}
+
+		// URL filter
+		if urlRegex != nil && !urlRegex.MatchString(item.URL) {
+			app.Trace("skipped news item %d: URL does not match the pattern: %s", item.ID, item.URL)
+			stats.skipped++
+			return nil
+		}
+
+		// news items
+		if news, err = expand(news[:0], item); err != nil {
+			switch {
+			case errors.Is(err, ErrBadURL):
+				stats.badURL++
+			case errors.Is(err, ErrBadTimestamp):
+				stats.badTS++
 			}
 
-			// validate the response
-			if !batch.Success {
-				return errors.New("response indicates an error")
+			app.Warn("skipped news item %d: %s", item.ID, err)
+			stats.skipped++
+			return nil // skip
+		}
+
+		for i := range news {
+			if maxTitle > 0 {
+				news[i].title = truncateTitle(news[i].title, maxTitle)
 			}
 
-			if len(batch.Data) == 0 {
-				return errors.New("response contains no news")
+			if len(titlePrefix) > 0 || len(titleSuffix) > 0 {
+				news[i].title = titlePrefix + news[i].title + titleSuffix
 			}
 
-			// next page URL
-			if batch.Pagination.Next, err = makeURL(batch.Pagination.Next); err != nil {
-				return failure("next page URL", err)
+			// check for timestamp in the future
+			if now := app.Now(); news[i].ts.After(now.Add(futureTolerance)) {
+				app.Warn("news item %d is dated in the future: %s", news[i].id, news[i].ts.Format(time.RFC3339))
+
+				if clampFuture {
+					news[i].ts = now.UTC().Truncate(time.Second)
+				}
 			}
 
-			// loop over the news batch
-			for i := range batch.Data {
-				item := &batch.Data[i]
+			// the GUID is made before the link is rewritten, so it does not depend on the rewrites
+			news[i].guid = makeGUID(&news[i])
 
-				// check for duplicate
-				if _, yes := seen[item.ID]; yes {
-					app.Warn("skipped a duplicate of the news item %d", item.ID)
+			if stripQuery || len(linkRewrites) > 0 {
+				if news[i].link, err = rewriteLink(news[i].link); err != nil {
+					app.Warn("skipped news item %d: %s", news[i].id, err)
+					stats.badURL++
+					stats.skipped++
 					continue
 				}
+			}
 
-				// yield
-				if err = yield(item); err != nil {
-					return err
-				}
+			if _, yes := guids[news[i].guid]; yes {
+				app.Warn("skipped a duplicate GUID %q produced from the news item %d", news[i].guid, item.ID)
+				stats.dups++
+				continue
+			}
 
-				// mark as seen
-				seen[item.ID] = struct{}{}
+			if err = yield(&news[i]); err != nil {
+				return err
 			}
 
-			// check if we've got enough news
-			if len(seen) >= numItems {
-				app.Info("processed %d news items.", len(seen))
-				return nil
+			guids[news[i].guid] = struct{}{}
+		}
+
+		return nil
+	})
+}
+
+// GUID scheme, one of: id (the numeric ID of the news item), tag (tag URI made of the link path)
+var guidScheme string
+
+// prefix of tag URI GUIDs, see RFC 4151
+const tagPrefix = "tag:vesti.ru,2024:"
+
+// make GUID for the given news item; tag URIs are derived from the link path only, so they stay
+// the same if the article is re-published under a different ID or on a different server
+func makeGUID(news *NewsItem) string {
+	if guidScheme == "tag" {
+		if u, err := url.Parse(news.link); err == nil {
+			if path := strings.TrimSuffix(u.EscapedPath(), "/"); len(path) > 0 {
+				return tagPrefix + path
 			}
 		}
 	}
+
+	return strconv.FormatUint(news.id, 10)
 }
 
-// convert RawNewsItem to NewsItem (a pipeline stage)
-func convert(src pump.Gen[*RawNewsItem], yield func(*NewsItem) error) error {
-	return src(func(item *RawNewsItem) error {
-		// news item
-		news := NewsItem{
-			id:    item.ID,
-			title: item.Title,
-			text:  item.Anons,
+// pipeline stage that drops news items with titles matching any of the previous items
+// after normalisation
+func dedupeTitles(numItems int) pump.Stage[*NewsItem, *NewsItem] {
+	titles := make(map[string]uint64, seenCapacity(numItems))
+
+	return pump.Filter(func(news *NewsItem) bool {
+		title := textutil.Normalize(news.title)
+
+		if id, yes := titles[title]; yes {
+			app.Trace("skipped news item %d as a duplicate of the news item %d (by title)", news.id, id)
+			stats.dups++
+			return false
 		}
 
-		// make link
-		var err error
+		titles[title] = news.id
+		return true
+	})
+}
 
-		if news.link, err = makeURL(item.URL); err != nil {
-			app.Warn("skipped news item %d: %s", item.ID, err)
-			return nil // skip
+// pipeline stage that drops news items from the site sections that already have the given
+// number of items; the dropped items do not count towards -num-items, so more pages are read
+// to fill the feed from other sections
+func limitPerCategory(limit int) pump.Stage[*NewsItem, *NewsItem] {
+	counts := make(map[string]int)
+
+	return pump.Filter(func(news *NewsItem) bool {
+		section := urlSection(news.link)
+
+		if counts[section] >= limit {
+			app.Trace("skipped news item %d: section %q is over the limit", news.id, section)
+			stats.skipped++
+			return false
 		}
 
-		// make timestamp
-		if news.ts, err = makeTS(item.DatePub.Day, item.DatePub.Time); err != nil {
-			app.Warn("skipped news item %d: %s", item.ID, err)
-			return nil // skip
+		counts[section]++
+		return true
+	})
+}
+
+// pipeline stage that collects all the news items, and passes them on sorted by timestamp,
+// newest first, and then by ID; the items held count towards -num-items while the source is read
+func sortItems(src pump.Gen[*NewsItem], yield func(*NewsItem) error) error {
+	var items []NewsItem
+
+	// the source may reuse the items, so they are copied
+	err := src(func(news *NewsItem) error {
+		items = append(items, *news)
+		stats.held++
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	stats.held = 0
+
+	slices.SortFunc(items, func(a, b NewsItem) int {
+		if r := b.ts.Compare(a.ts); r != 0 {
+			return r
 		}
 
-		return yield(&news)
+		return cmp.Compare(b.id, a.id)
 	})
+
+	for i := range items {
+		if err = yield(&items[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-var xmlHeader = `<?xml version="1.0" encoding="UTF-8"?>
-<rss version="2.0">
-<channel>
-  <title>Новости</title>
-  <link>https://www.vesti.ru/news</link>
-  <description>Новости дня от Вести.Ru, интервью, репортажи, фото и видео, новости Москвы и регионов России, новости экономики, погода</description>
-  <copyright>© ` + strconv.Itoa(time.Now().Year()) + ` Сетевое издание &quot;Вести.Ру&quot;</copyright>
-  <image>
-    <link>https://www.vesti.ru/news</link>
-    <title>Новости</title>
-    <url>https://www.vesti.ru/i/logo_fb.png</url>
-  </image>
-`
+// Expander function: appends to the given slice zero or more news items produced from the given
+// raw item; each of the produced items must have a unique GUID. The default converts one raw item
+// to exactly one news item.
+var expand = expandOne
+
+// convert RawNewsItem to exactly one NewsItem
+func expandOne(dest []NewsItem, item *RawNewsItem) (_ []NewsItem, err error) {
+	// news item
+	news := NewsItem{
+		id:    item.ID,
+		title: item.Title,
+		text:  item.Anons,
+	}
 
-const (
-	xmlPrefix    = "<item><title>"
-	xmlPrefixLen = len(xmlPrefix)
-)
+	// make link
+	if news.link, err = makeURL(item.URL); err != nil {
+		return dest, err
+	}
+
+	// make timestamp
+	if news.ts, err = makeTS(item.DatePub.Day, item.DatePub.Time); err != nil {
+		return dest, err
+	}
+
+	return append(dest, news), nil
+}
 
 // make HTTP request and return the response body
-func getResponse(reqURL string, client *http.Client) ([]byte, error) {
+func getResponse(ctx context.Context, reqURL string, client *http.Client) (body []byte, err error) {
+	err = retryTransient(ctx, reqURL, func() error {
+		resp, err := openResponse(ctx, reqURL, "application/json", client)
+
+		if err != nil {
+			return err
+		}
+
+		defer resp.Body.Close()
+
+		body, err = readResponse(resp.Body)
+		return err
+	})
+
+	return
+}
+
+// call the given request function, and if it fails with a transient error, call it once again
+// after a short delay; the transient errors are DNS lookup failures, and the responses not in JSON,
+// because their server sometimes returns HTML error pages with status 200
+func retryTransient(ctx context.Context, reqURL string, fn func() error) error {
+	err := fn()
+
+	if !errors.Is(err, errNotJSON) && !errors.Is(err, errDNS) {
+		return err
+	}
+
+	if retryBudget > 0 && stats.retries >= retryBudget {
+		return failure("retry budget of "+strconv.Itoa(retryBudget)+" exhausted", err)
+	}
+
+	stats.retries++
+
+	app.Warn("retrying request to %s in %s: %s", reqURL, retryDelay, err)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(retryDelay):
+	}
+
+	return fn()
+}
+
+// delay before retrying a request
+const retryDelay = time.Second
+
+// maximum number of retries for the whole run, 0 for no limit
+var retryBudget int
+
+// error from readResponse for the responses not in JSON format
+var errNotJSON = errors.New("response is either empty, or in a wrong format")
+
+// error from openResponse for DNS lookup failures
+var errDNS = errors.New("DNS lookup failed, check DNS settings and network connectivity")
+
+// make HTTP request and return the response with status code 200; the caller must close
+// the response body
+func openResponse(ctx context.Context, reqURL, accept string, client *http.Client) (*http.Response, error) {
 	// HTTP request
-	req, err := http.NewRequestWithContext(app.Context(), http.MethodGet, reqURL, nil)
+	var stall *stallReader
+
+	if stallTimeout > 0 {
+		stall = &stallReader{}
+		ctx, stall.cancel = context.WithCancel(ctx)
+	}
+
+	req, err := newRequest(ctx, http.MethodGet, reqURL, accept)
 
 	if err != nil {
-		return nil, failure("creating HTTP request", err)
+		if stall != nil {
+			stall.cancel()
+		}
+
+		return nil, err
 	}
 
-	// HTTP headers
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("User-Agent", "vesti-rss/"+version)
+	if len(basicUser) > 0 {
+		req.SetBasicAuth(basicUser, basicPass)
+	}
 
 	// make the request
 	resp, err := client.Do(req)
 
 	if err != nil {
+		if stall != nil {
+			stall.cancel()
+		}
+
+		if dnsErr := (*net.DNSError)(nil); errors.As(err, &dnsErr) {
+			return nil, fmt.Errorf("%w: %w", errDNS, dnsErr)
+		}
+
 		return nil, failure("making HTTP request", err)
 	}
 
-	defer resp.Body.Close()
+	// watch the progress of reading the response body
+	if stall != nil {
+		stall.src = resp.Body
+		stall.timer = time.AfterFunc(stallTimeout, stall.expire)
+		resp.Body = stall
+	}
 
 	// check HTTP status
 	if resp.StatusCode != http.StatusOK {
 		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
 
 		msg := "HTTP request returned status code " + strconv.Itoa(resp.StatusCode)
 
@@ -288,10 +1826,15 @@ func getResponse(reqURL string, client *http.Client) ([]byte, error) {
 		return nil, errors.New(msg)
 	}
 
+	return resp, nil
+}
+
+// read the whole response body
+func readResponse(src io.Reader) ([]byte, error) {
 	// strangely enough, their server returns errors in HTML and with HTTP code 200,
 	// so here we have to read the whole body to detect such an error before attempting
 	// to de-serialise the content
-	body, err := io.ReadAll(resp.Body)
+	body, err := io.ReadAll(src)
 
 	if err != nil {
 		return nil, failure("reading response", err)
@@ -300,23 +1843,163 @@ func getResponse(reqURL string, client *http.Client) ([]byte, error) {
 	body = bytes.TrimSpace(body)
 
 	if len(body) == 0 || body[0] != '{' {
-		return nil, errors.New("response is either empty, or in a wrong format")
+		return nil, errNotJSON
 	}
 
 	// all done
 	return body, nil
 }
 
+// response body reader that cancels the request if no data arrive within the stall timeout
+type stallReader struct {
+	src     io.ReadCloser
+	timer   *time.Timer
+	cancel  context.CancelFunc
+	stalled atomic.Bool
+}
+
+func (r *stallReader) Read(buff []byte) (n int, err error) {
+	n, err = r.src.Read(buff)
+
+	switch {
+	case r.stalled.Load():
+		err = errors.New("no data received within the stall timeout of " + stallTimeout.String())
+	case n > 0:
+		r.timer.Reset(stallTimeout)
+	}
+
+	return
+}
+
+func (r *stallReader) Close() error {
+	r.timer.Stop()
+	r.cancel()
+
+	return r.src.Close()
+}
+
+func (r *stallReader) expire() {
+	r.stalled.Store(true)
+	r.cancel()
+}
+
+// check if the content type is one of the NDJSON media types
+func isNDJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+
+	if err != nil {
+		return false
+	}
+
+	switch mediaType {
+	case "application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines":
+		return true
+	default:
+		return false
+	}
+}
+
+// make HTTP request with the standard set of headers
+func newRequest(ctx context.Context, method, reqURL, accept string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, nil)
+
+	if err != nil {
+		return nil, failure("creating HTTP request", err)
+	}
+
+	// HTTP headers
+	req.Header.Set("Accept", accept)
+	req.Header.Set("User-Agent", userAgent)
+
+	// the extra headers may carry credentials, so they are sent to the API server only,
+	// and not to the item links that may point elsewhere
+	if isServerURL(req.URL) {
+		for name, values := range extraHeaders {
+			req.Header[name] = values
+		}
+	}
+
+	return req, nil
+}
+
+// User-Agent HTTP header
+var userAgent = "vesti-rss/" + version
+
+// extra HTTP headers from the command line
+var extraHeaders = make(http.Header)
+
+// flag.Value implementation for the repeatable "-header" flag
+type headerList struct{}
+
+// only header names are shown, because values may carry credentials
+func (headerList) String() string {
+	names := make([]string, 0, len(extraHeaders))
+
+	for name := range extraHeaders {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func (headerList) Set(s string) error {
+	name, value, ok := strings.Cut(s, ":")
+
+	if !ok {
+		return errors.New("missing colon in HTTP header " + strconv.Quote(s))
+	}
+
+	if name = strings.TrimSpace(name); !isValidHeaderName(name) {
+		return errors.New("invalid HTTP header name " + strconv.Quote(name))
+	}
+
+	if value = strings.TrimSpace(value); strings.ContainsAny(value, "\r\n\x00") {
+		return errors.New("invalid value of HTTP header " + strconv.Quote(name))
+	}
+
+	name = textproto.CanonicalMIMEHeaderKey(name)
+
+	switch name {
+	case "Host", "Content-Length", "Transfer-Encoding", "Connection":
+		return errors.New("HTTP header " + strconv.Quote(name) + " cannot be overridden")
+	}
+
+	extraHeaders.Add(name, value)
+	return nil
+}
+
+// check if the string is a valid HTTP header name (token, as in RFC 7230, section 3.2.6)
+func isValidHeaderName(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c <= ' ' || c >= 0x7F || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) >= 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// errors from makeURL and makeTS
+var (
+	ErrBadURL       = errors.New("bad URL")
+	ErrBadTimestamp = errors.New("bad timestamp")
+)
+
 // make full URL with the given path, and validate it
 func makeURL(s string) (string, error) {
 	if len(s) == 0 || s[0] != '/' {
-		return "", errors.New("invalid path: " + strconv.Quote(s))
+		return "", fmt.Errorf("%w: invalid path: %s", ErrBadURL, strconv.Quote(s))
 	}
 
 	u, err := url.ParseRequestURI(server + s)
 
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("%w: %w", ErrBadURL, err)
 	}
 
 	return u.String(), nil
@@ -328,7 +2011,7 @@ func makeTS(d, t string) (time.Time, error) {
 	m := matchDate(d)
 
 	if len(m) != 4 {
-		return time.Time{}, errors.New("invalid date: " + strconv.Quote(d))
+		return time.Time{}, fmt.Errorf("%w: invalid date: %s", ErrBadTimestamp, strconv.Quote(d))
 	}
 
 	// extract date values
@@ -336,14 +2019,14 @@ func makeTS(d, t string) (time.Time, error) {
 	month := monthMap[m[2]]
 
 	if month == 0 {
-		return time.Time{}, errors.New("invalid month: " + strconv.Quote(d))
+		return time.Time{}, fmt.Errorf("%w: invalid month: %s", ErrBadTimestamp, strconv.Quote(d))
 	}
 
 	day, _ := strconv.Atoi(m[1])
 
 	// match time
 	if m = matchTime(t); len(m) != 3 {
-		return time.Time{}, errors.New("invalid time: " + strconv.Quote(d))
+		return time.Time{}, fmt.Errorf("%w: invalid time: %s", ErrBadTimestamp, strconv.Quote(t))
 	}
 
 	// extract time values
@@ -376,24 +2059,7 @@ var (
 	msk *time.Location
 )
 
-// output writers
-func write(data []byte) (err error) {
-	if _, err = os.Stdout.Write(data); err != nil {
-		err = failure("writing to STDOUT", err)
-	}
-
-	return
-}
-
-func writeString(data string) (err error) {
-	if _, err = os.Stdout.WriteString(data); err != nil {
-		err = failure("writing to STDOUT", err)
-	}
-
-	return
-}
-
-// compose error message from a prefix and an error
+// compose error message from a prefix and an error, keeping the original error in the chain
 func failure(prefix string, err error) error {
-	return errors.New(prefix + ": " + err.Error())
+	return fmt.Errorf("%s: %w", prefix, err)
 }
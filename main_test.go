@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/maxim2266/pump"
+)
+
+func TestTruncateTitle(t *testing.T) {
+	cases := []struct {
+		title string
+		n     int
+		exp   string
+	}{
+		{"Новость", 1, "…"},
+		{"Новость дня", 5, "Ново…"},
+		{"Новость дня", 9, "Новость…"},
+		{"Новость дня", 8, "Новость…"},
+		{"Новость  дня", 10, "Новость…"},
+		{"Новость дня", 10, "Новость д…"},
+		{"Новость дня", 11, "Новость дня"},
+		{"Новость дня", 100, "Новость дня"},
+		{"a😀b世c", 3, "a😀…"},
+		{"a😀b世c", 4, "a😀b…"},
+		{"", 1, ""},
+	}
+
+	for _, c := range cases {
+		if got := truncateTitle(c.title, c.n); got != c.exp {
+			t.Errorf("truncateTitle(%q, %d): got %q, expected %q", c.title, c.n, got, c.exp)
+		}
+	}
+}
+
+// backfill of 10k news items with half of them dropped by the filters after the source stage,
+// with the sets pre-sized to the requested number of items, and to the estimated capacity
+func BenchmarkSeenCapacity(b *testing.B) {
+	const numItems, read = 5000, 10000
+
+	defer func(pages int, drops bool) { maxPages, dropsAfterSource = pages, drops }(maxPages, dropsAfterSource)
+
+	maxPages, dropsAfterSource = read/pageSize, true
+
+	for _, bench := range [...]struct {
+		name string
+		size int
+	}{
+		{"fixed", numItems + pageSize},
+		{"estimated", seenCapacity(numItems)},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for range b.N {
+				seen := make(map[uint64]struct{}, bench.size)
+
+				for id := range uint64(read) {
+					seen[id] = struct{}{}
+				}
+			}
+		})
+	}
+}
+
+// define the flags for tests on the command line flag set
+func defineTestFlags() {
+	if flag.Lookup("x-value") == nil {
+		flag.String("x-value", "", "test flag with a value")
+		flag.Bool("x-bool", false, "test boolean flag")
+	}
+}
+
+func TestExpandArgs(t *testing.T) {
+	defineTestFlags()
+
+	dir, n := t.TempDir(), 0
+
+	// create a file with the given arguments, and return the reference to it
+	file := func(text string) string {
+		n++
+		name := filepath.Join(dir, strconv.Itoa(n))
+
+		if err := os.WriteFile(name, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		return "@" + name
+	}
+
+	cases := []struct {
+		args []string
+		exp  []string
+		fail bool
+	}{
+		{args: []string{"-x-value", "abc", "-x-bool"}, exp: []string{"-x-value", "abc", "-x-bool"}},
+		{args: []string{"-x-value", "@x"}, exp: []string{"-x-value", "@x"}},
+		{args: []string{"-x-bool", "@x"}, fail: true},
+		{args: []string{"rest", "@x"}, exp: []string{"rest", "@x"}},
+		{args: []string{"--", "@x"}, exp: []string{"--", "@x"}},
+		{args: []string{file("# comment\n\n  -x-value  a b c \n-x-bool\n")}, exp: []string{"-x-value", "a b c", "-x-bool"}},
+		{args: []string{file("--x-value a\n-x-value=b c\n")}, exp: []string{"--x-value", "a", "-x-value=b c"}},
+		{args: []string{file("-x-bool false\n-x-bool\tfalse\n")}, exp: []string{"-x-bool=false", "-x-bool=false"}},
+		{args: []string{file("-x-unknown value\n")}, exp: []string{"-x-unknown=value"}},
+		{args: []string{file("-x-value a\nstray\n")}, fail: true},
+		{args: []string{file("@other\n")}, fail: true},
+	}
+
+	for _, c := range cases {
+		got, err := expandArgs(c.args)
+
+		switch {
+		case c.fail && err == nil:
+			t.Errorf("expandArgs(%q): unexpected success: %q", c.args, got)
+		case !c.fail && err != nil:
+			t.Errorf("expandArgs(%q): unexpected error: %s", c.args, err)
+		case !c.fail && !slices.Equal(got, c.exp):
+			t.Errorf("expandArgs(%q): got %q, expected %q", c.args, got, c.exp)
+		}
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	defer func(v bool) { noSchemaCheck = v }(noSchemaCheck)
+
+	valid := []RawNewsItem{{Title: "Новость"}, {Title: "Новость", URL: "/news/1"}}
+	invalid := []RawNewsItem{{Title: " ", URL: "/news/1"}, {URL: "/news/2"}}
+
+	for _, c := range [...]struct {
+		items   []RawNewsItem
+		disable bool
+		fail    bool
+	}{
+		{valid, false, false},
+		{invalid, false, true},
+		{nil, false, true},
+		{invalid, true, false},
+		{nil, true, false},
+	} {
+		noSchemaCheck = c.disable
+
+		if err := checkSchema(c.items); (err != nil) != c.fail {
+			t.Errorf("checkSchema(%v) with -no-schema-check=%t: unexpected result: %v", c.items, c.disable, err)
+		}
+	}
+}
+
+func TestWriteFeed(t *testing.T) {
+	defer func(c, p bool, footer, end string) {
+		compact, embedProvenance, xmlFooter, itemEnd = c, p, footer, end
+	}(compact, embedProvenance, xmlFooter, itemEnd)
+
+	saved := stats
+
+	defer func() { stats = saved }()
+
+	// the provenance comment lists the flags set, and must not end up with "--" in it
+	defineTestFlags()
+
+	defer flag.Set("x-value", flag.Lookup("x-value").Value.String())
+
+	if err := flag.Set("x-value", "a--b-"); err != nil {
+		t.Fatal(err)
+	}
+
+	items := []*NewsItem{
+		{id: 2, title: "Новость <2> & \"цитата\"", text: "Анонс -- 2", link: "https://www.vesti.ru/news/2", guid: "2",
+			ts: time.Date(2025, 3, 2, 10, 0, 0, 0, time.UTC)},
+		{id: 1, title: "Новость 1", text: "]]> Анонс 1", link: "https://www.vesti.ru/news/1?a=1&b=2", guid: "1",
+			ts: time.Date(2025, 3, 1, 9, 30, 0, 0, time.UTC)},
+	}
+
+	for _, mode := range [...]string{"default", "compact"} {
+		if mode == "compact" {
+			setCompact()
+		}
+
+		embedProvenance = true
+
+		header, err := makeHeader("1,2", "Monday")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out bytes.Buffer
+
+		if err = writeFeed(&out, header, pump.FromSlice(items)); err != nil {
+			t.Fatalf("%s: %s", mode, err)
+		}
+
+		// well-formedness
+		dec := xml.NewDecoder(bytes.NewReader(out.Bytes()))
+		comments := 0
+
+		for {
+			tok, err := dec.Token()
+
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				t.Fatalf("%s: malformed XML: %s\n%s", mode, err, out.Bytes())
+			}
+
+			if _, ok := tok.(xml.Comment); ok {
+				comments++
+			}
+		}
+
+		if comments != 1 {
+			t.Errorf("%s: expected one provenance comment, got %d", mode, comments)
+		}
+
+		// content
+		var feed struct {
+			Channel struct {
+				SkipHours []int    `xml:"skipHours>hour"`
+				SkipDays  []string `xml:"skipDays>day"`
+				Items     []struct {
+					Title       string `xml:"title"`
+					Description string `xml:"description"`
+					Link        string `xml:"link"`
+					PubDate     string `xml:"pubDate"`
+				} `xml:"item"`
+			} `xml:"channel"`
+		}
+
+		if err = xml.Unmarshal(out.Bytes(), &feed); err != nil {
+			t.Fatalf("%s: %s", mode, err)
+		}
+
+		if ch := feed.Channel; !slices.Equal(ch.SkipHours, []int{1, 2}) || !slices.Equal(ch.SkipDays, []string{"Monday"}) {
+			t.Errorf("%s: unexpected skip lists: %v, %v", mode, ch.SkipHours, ch.SkipDays)
+		}
+
+		if len(feed.Channel.Items) != len(items) {
+			t.Fatalf("%s: got %d items, expected %d", mode, len(feed.Channel.Items), len(items))
+		}
+
+		for i, item := range feed.Channel.Items {
+			if item.Title != items[i].title || item.Description != items[i].text || item.Link != items[i].link {
+				t.Errorf("%s: item %d mismatch: %+v", mode, i, item)
+			}
+
+			if ts, err := time.Parse(time.RFC1123Z, item.PubDate); err != nil || !ts.Equal(items[i].ts) {
+				t.Errorf("%s: item %d: invalid pubDate %q", mode, i, item.PubDate)
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maxim2266/vesti-rss/internal/app"
+
+	"github.com/maxim2266/pump"
+)
+
+// feed file to take the newest timestamp from; only the news items newer than that are emitted
+var newerThanFeed string
+
+// read the timestamp of the newest item from the given feed file, either RSS 2.0 or RSS 1.0;
+// returns zero time if the file does not exist, or has no items
+func readNewestTS(path string) (time.Time, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			app.Info("feed file %q does not exist, all news items are new", path)
+			return time.Time{}, nil
+		}
+
+		return time.Time{}, failure("reading feed file", err)
+	}
+
+	var feed struct {
+		Items []struct {
+			PubDate string `xml:"pubDate"`
+		} `xml:"channel>item"`
+
+		RDFItems []struct {
+			Date string `xml:"http://purl.org/dc/elements/1.1/ date"`
+		} `xml:"item"`
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+
+	dec.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		if cm := charmaps[strings.ToLower(label)]; cm != nil {
+			return cm.NewDecoder().Reader(input), nil
+		}
+
+		return nil, errors.New("unsupported encoding: " + strconv.Quote(label))
+	}
+
+	if err = dec.Decode(&feed); err != nil {
+		return time.Time{}, failure("parsing feed file", err)
+	}
+
+	var newest time.Time
+
+	update := func(s string, layouts ...string) error {
+		s = strings.TrimSpace(s)
+
+		for _, layout := range layouts {
+			if ts, err := time.Parse(layout, s); err == nil {
+				if ts.After(newest) {
+					newest = ts
+				}
+
+				return nil
+			}
+		}
+
+		return errors.New("invalid item timestamp in feed file: " + strconv.Quote(s))
+	}
+
+	for _, item := range feed.Items {
+		if err = update(item.PubDate, time.RFC1123Z, time.RFC1123); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	for _, item := range feed.RDFItems {
+		if err = update(item.Date, time.RFC3339); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	if newest.IsZero() {
+		app.Info("feed file %q has no items, all news items are new", path)
+	} else {
+		app.Info("emitting news items newer than %s", newest.UTC().Format(time.RFC3339))
+	}
+
+	return newest, nil
+}
+
+// pipeline stage that drops the news items not newer than the given time; the dropped items
+// are counted as present, so that the source stops after reading the usual number of items
+func skipNotNewer(newest time.Time) pump.Stage[*NewsItem, *NewsItem] {
+	return pump.Filter(func(news *NewsItem) bool {
+		if !news.ts.After(newest) {
+			app.Trace("skipped news item %d not newer than the feed file", news.id)
+			stats.present++
+			return false
+		}
+
+		return true
+	})
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/maxim2266/vesti-rss/internal/app"
+	"github.com/maxim2266/vesti-rss/xmlutil"
+
+	"github.com/maxim2266/pump"
+)
+
+// output file, and the mode of writing it
+var outputPath, outputMode string
+
+// write the hash of the item set to a sidecar file, and skip the update if the hash is unchanged
+var writeHash bool
+
+// write RSS document to the output file; the file is replaced atomically, so that
+// an existing feed is never left partially written
+func writeOutputFile(header []byte, src pump.Gen[*NewsItem], numItems int) error {
+	// existing content
+	var (
+		existing [][]byte
+		present  map[string]struct{}
+	)
+
+	if outputMode == "append" || len(changelogFile) > 0 {
+		var err error
+
+		if existing, present, err = readFeedItems(outputPath); err != nil {
+			return err
+		}
+	}
+
+	before := feedEntries(existing)
+
+	// temporary file
+	tmp, err := os.CreateTemp(filepath.Dir(outputPath), "."+filepath.Base(outputPath)+".*")
+
+	if err != nil {
+		return failure("creating output file", err)
+	}
+
+	defer func() {
+		if tmp != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	// write
+	if outputMode == "append" {
+		err = appendFeed(tmp, header, pump.Bind(src, skipPresent(present)), existing, numItems)
+	} else {
+		err = writeFeed(tmp, header, src)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// replace the output file
+	if err = tmp.Chmod(0644); err != nil {
+		return failure("output file", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return failure("writing output file", err)
+	}
+
+	// changes
+	var changes []byte
+
+	if len(changelogFile) > 0 {
+		var items [][]byte
+
+		if items, _, err = readFeedItems(tmp.Name()); err != nil {
+			return err
+		}
+
+		changes = makeChangelog(before, feedEntries(items))
+	}
+
+	// item set hash
+	var etag []byte
+
+	if writeHash {
+		if etag, err = feedHash(tmp.Name()); err != nil {
+			return err
+		}
+
+		if unchanged(etag) {
+			app.Info("output file is unchanged, hash " + string(etag))
+			return writeChangelog(changes)
+		}
+	}
+
+	if err = os.Rename(tmp.Name(), outputPath); err != nil {
+		return failure("replacing output file", err)
+	}
+
+	tmp = nil
+
+	if writeHash {
+		if err = os.WriteFile(outputPath+".etag", append(etag, '\n'), 0644); err != nil {
+			return failure("writing hash file", err)
+		}
+	}
+
+	return writeChangelog(changes)
+}
+
+// write the changelog file, if requested
+func writeChangelog(changes []byte) error {
+	if len(changelogFile) == 0 {
+		return nil
+	}
+
+	if err := writeFileAtomic(changelogFile, changes); err != nil {
+		return failure("changelog file", err)
+	}
+
+	return nil
+}
+
+// write the given data to the file, replacing it atomically
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = tmp.Write(data); err == nil {
+		if err = tmp.Chmod(0644); err == nil {
+			err = tmp.Close()
+		}
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
+
+// compute the hash of the item set (GUIDs and timestamps) in the given feed file
+func feedHash(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, failure("reading output file", err)
+	}
+
+	h := sha256.New()
+
+	for _, item := range matchItem(data, -1) {
+		for _, m := range [...][][]byte{matchGUID(item), matchPubDate(item)} {
+			if len(m) == 2 {
+				h.Write(m[1])
+			}
+
+			h.Write([]byte{0})
+		}
+	}
+
+	return hex.AppendEncode(nil, h.Sum(nil)), nil
+}
+
+// check if the existing output file has the given hash
+func unchanged(etag []byte) bool {
+	if _, err := os.Stat(outputPath); err != nil {
+		return false
+	}
+
+	old, err := os.ReadFile(outputPath + ".etag")
+
+	return err == nil && bytes.Equal(bytes.TrimSpace(old), etag)
+}
+
+// write RSS document with the new items inserted after the existing ones, dropping the existing
+// items with the oldest <pubDate> to keep the total number within the limit
+func appendFeed(out *os.File, header []byte, src pump.Gen[*NewsItem], existing [][]byte, numItems int) error {
+	// new items
+	var buff bytes.Buffer
+
+	items := &countingWriter{w: &buff}
+
+	if err := writeItems(items, src); err != nil {
+		return err
+	}
+
+	// existing items to keep
+	if drop := len(existing) + items.items - numItems; drop > 0 {
+		drop = min(drop, len(existing))
+		existing = dropOldest(existing, drop)
+
+		app.Info("dropped %d old news items from the output file", drop)
+	}
+
+	// write out
+	w := newOutputWriter(out)
+
+	if err := write(w, header); err != nil {
+		return err
+	}
+
+	for _, item := range existing {
+		if err := write(w, bytes.TrimRight(item, "\r\n")); err != nil {
+			return err
+		}
+
+		if err := writeString(w, itemEnd); err != nil {
+			return err
+		}
+	}
+
+	if err := write(w, buff.Bytes()); err != nil {
+		return err
+	}
+
+	return writeString(w, xmlFooter)
+}
+
+// drop the given number of items with the oldest <pubDate>, keeping the order of the rest;
+// the items without a valid <pubDate> are taken as the oldest
+func dropOldest(items [][]byte, n int) [][]byte {
+	ts := make([]time.Time, len(items))
+
+	for i, item := range items {
+		if m := matchPubDate(item); len(m) == 2 {
+			ts[i], _ = time.Parse(time.RFC1123Z, string(m[1]))
+		}
+	}
+
+	// indices of the items, oldest first; the stable sort drops the items
+	// with the same timestamp in the file order
+	order := make([]int, len(items))
+
+	for i := range order {
+		order[i] = i
+	}
+
+	slices.SortStableFunc(order, func(a, b int) int { return ts[a].Compare(ts[b]) })
+
+	dropped := make([]bool, len(items))
+
+	for _, i := range order[:n] {
+		dropped[i] = true
+	}
+
+	res := items[:0]
+
+	for i, item := range items {
+		if !dropped[i] {
+			res = append(res, item)
+		}
+	}
+
+	return res
+}
+
+// pipeline stage that drops the news items already present in the output file
+func skipPresent(present map[string]struct{}) pump.Stage[*NewsItem, *NewsItem] {
+	return pump.Filter(func(news *NewsItem) bool {
+		if _, yes := present[string(xmlutil.AppendEscaped(nil, news.guid))]; yes {
+			app.Trace("skipped news item %d already present in the output file", news.id)
+			stats.present++
+			return false
+		}
+
+		return true
+	})
+}
+
+// read <item> elements and their GUIDs from an existing feed file; a missing file is not an error
+func readFeedItems(path string) (items [][]byte, guids map[string]struct{}, err error) {
+	guids = make(map[string]struct{})
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		} else {
+			err = failure("reading output file", err)
+		}
+
+		return
+	}
+
+	// the file is in the output encoding
+	if outputCharmap != nil {
+		if data, err = outputCharmap.NewDecoder().Bytes(data); err != nil {
+			return nil, nil, failure("decoding output file", err)
+		}
+	}
+
+	// the items are between the channel header and </channel>
+	end := bytes.LastIndex(data, []byte("</channel>"))
+
+	if end < 0 {
+		return nil, nil, errors.New("output file is not an RSS feed: " + strconv.Quote(path))
+	}
+
+	for _, item := range matchItem(data[:end], -1) {
+		items = append(items, item)
+
+		if m := matchGUID(item); len(m) == 2 {
+			guids[string(m[1])] = struct{}{}
+		}
+	}
+
+	return
+}
+
+var (
+	matchItem = regexp.MustCompile(`(?s)<item>.*?</item>\n?`).FindAll
+	matchGUID = regexp.MustCompile(`<guid[^>]*>([^<]*)</guid>`).FindSubmatch
+
+	matchPubDate = regexp.MustCompile(`<pubDate>([^<]*)</pubDate>`).FindSubmatch
+)
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/maxim2266/vesti-rss/xmlutil"
+
+	"github.com/maxim2266/pump"
+)
+
+// output format, one of: rss (RSS 2.0), rdf (RSS 1.0)
+var feedFormat string
+
+// flags that have no meaning in RSS 1.0 output
+var rdfUnsupported = []string{
+	"output-mode", "max-item-bytes", "max-output-bytes", "template", "item-order",
+	"skip-hours", "skip-days", "image-description", "icon-url", "write-hash", "changelog-file",
+}
+
+// write RSS 1.0 document; the channel lists all the items before the items themselves,
+// so the whole document is composed in memory
+func writeRDF(out io.Writer, header []byte, src pump.Gen[*NewsItem]) error {
+	var seq, items []byte
+
+	err := src(func(news *NewsItem) error {
+		seq = append(xmlutil.AppendEscaped(append(seq, `      <rdf:li rdf:resource="`...), news.link), "\"/>\n"...)
+		items = appendRDFItem(items, news)
+
+		countEmitted(news)
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	doc := append(append(append(append(header, seq...), rdfChannelEnd...), items...), rdfFooter...)
+
+	if compact {
+		doc = compactXML(doc)
+	}
+
+	return write(newOutputWriter(out), crlfLines(doc))
+}
+
+// append RSS 1.0 <item> element for the given news item
+func appendRDFItem(dest []byte, news *NewsItem) []byte {
+	dest = xmlutil.AppendEscaped(append(dest, `<item rdf:about="`...), news.link)
+	dest = xmlutil.AppendEscaped(append(dest, `"><title>`...), news.title)
+	dest = xmlutil.AppendEscaped(append(dest, "</title><link>"...), news.link)
+	dest = xmlutil.AppendEscaped(append(dest, "</link><description>"...), news.text)
+	dest = xmlutil.AppendEscaped(dest, readMoreSuffix(news))
+	dest = news.ts.In(outputTZ).AppendFormat(append(dest, "</description><dc:date>"...), time.RFC3339)
+	dest = xmlutil.AppendEscaped(append(dest, "</dc:date><dc:creator>Вести.Ру</dc:creator><dc:identifier>"...), news.guid)
+
+	return append(dest, "</dc:identifier></item>\n"...)
+}
+
+// compose RSS 1.0 document header, up to the list of items in the channel
+func makeRDFHeader(year int) []byte {
+	header := appendProvenance(append(append([]byte(xmlDecl), outputEncoding...), xmlDeclEnd...))
+	header = append(header, rdfHeader...)
+
+	if len(xmlBase) > 0 {
+		header = xmlutil.AppendAttr(header, "xml:base", xmlBase)
+	}
+
+	return append(strconv.AppendInt(append(header, rdfChannel...), int64(year), 10), rdfChannelTail...)
+}
+
+// RSS 1.0 document parts
+const (
+	rdfHeader = `<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns="http://purl.org/rss/1.0/" xmlns:dc="http://purl.org/dc/elements/1.1/"`
+
+	rdfChannel = `>
+<channel rdf:about="https://www.vesti.ru/news">
+  <title>Новости</title>
+  <link>https://www.vesti.ru/news</link>
+  <description>Новости дня от Вести.Ru, интервью, репортажи, фото и видео, новости Москвы и регионов России, новости экономики, погода</description>
+  <dc:rights>© `
+
+	rdfChannelTail = ` Сетевое издание &quot;Вести.Ру&quot;</dc:rights>
+  <image rdf:resource="https://www.vesti.ru/i/logo_fb.png"/>
+  <items>
+    <rdf:Seq>
+`
+
+	rdfChannelEnd = `    </rdf:Seq>
+  </items>
+</channel>
+<image rdf:about="https://www.vesti.ru/i/logo_fb.png">
+  <title>Новости</title>
+  <link>https://www.vesti.ru/news</link>
+  <url>https://www.vesti.ru/i/logo_fb.png</url>
+</image>
+`
+
+	rdfFooter = "</rdf:RDF>\n"
+)
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/maxim2266/vesti-rss/internal/app"
+)
+
+// file to write the run result to
+var statusFile string
+
+// run result, as written to the status file
+type runStatus struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	ExitCode int       `json:"exit_code"`
+	Error    string    `json:"error,omitempty"`
+
+	Pages      int `json:"pages"`
+	Fetched    int `json:"fetched"`
+	Emitted    int `json:"emitted"`
+	Skipped    int `json:"skipped"`
+	Duplicates int `json:"duplicates"`
+
+	Newest *time.Time `json:"newest,omitempty"`
+	Oldest *time.Time `json:"oldest,omitempty"`
+}
+
+// register exit handler to write the run result to the status file
+func writeStatusAtExit(start time.Time) {
+	app.AtExit(func() {
+		status := runStatus{
+			Start:      start,
+			End:        app.Now(),
+			ExitCode:   app.ExitCode(),
+			Error:      app.ErrorMessage(),
+			Pages:      stats.pages,
+			Fetched:    stats.fetched,
+			Emitted:    stats.emitted,
+			Skipped:    stats.skipped,
+			Duplicates: stats.dups,
+		}
+
+		if !stats.newest.IsZero() {
+			status.Newest, status.Oldest = &stats.newest, &stats.oldest
+		}
+
+		if err := writeStatus(&status); err != nil {
+			app.Error(err.Error())
+		}
+	})
+}
+
+// write the status file atomically
+func writeStatus(status *runStatus) error {
+	data, err := json.MarshalIndent(status, "", "  ")
+
+	if err != nil {
+		return failure("status file", err)
+	}
+
+	if err = writeFileAtomic(statusFile, append(data, '\n')); err != nil {
+		return failure("writing status file", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"log/syslog"
+	"strconv"
+	"time"
+
+	"github.com/maxim2266/vesti-rss/internal/app"
+)
+
+// connection to the local syslog daemon for news item events, nil if not enabled
+var itemSyslog *syslog.Writer
+
+// syslog facilities for news item events
+var syslogFacilities = map[string]syslog.Priority{
+	"user":   syslog.LOG_USER,
+	"daemon": syslog.LOG_DAEMON,
+	"local0": syslog.LOG_LOCAL0,
+	"local1": syslog.LOG_LOCAL1,
+	"local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3,
+	"local4": syslog.LOG_LOCAL4,
+	"local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6,
+	"local7": syslog.LOG_LOCAL7,
+}
+
+// connect to the local syslog daemon to send news item events with the given facility
+func openItemSyslog(facility string) error {
+	prio, ok := syslogFacilities[facility]
+
+	if !ok {
+		return errors.New("invalid syslog facility: " + strconv.Quote(facility))
+	}
+
+	w, err := syslog.New(prio|syslog.LOG_INFO, "vesti-rss")
+
+	if err != nil {
+		return failure("syslog", err)
+	}
+
+	app.AtExit(func() { w.Close() })
+
+	itemSyslog = w
+	return nil
+}
+
+// send the emitted news item as a syslog event; failures are reported, but do not stop the feed
+func sendItemSyslog(news *NewsItem) {
+	msg := strconv.AppendUint(append(make([]byte, 0, 256), "id="...), news.id, 10)
+	msg = strconv.AppendQuote(append(msg, " title="...), news.title)
+	msg = strconv.AppendQuote(append(msg, " link="...), news.link)
+	msg = news.ts.AppendFormat(append(msg, " ts="...), time.RFC3339)
+
+	if _, err := itemSyslog.Write(msg); err != nil {
+		app.Warn("sending news item %d to syslog: %s", news.id, err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/maxim2266/vesti-rss/xmlutil"
+)
+
+// default output template, matching the built-in layout
+//
+//go:embed feed.tmpl
+var defaultTemplate string
+
+// output template, or nil for the built-in layout
+var feedTemplate *template.Template
+
+// data for the "header" template
+type headerData struct {
+	Encoding         string
+	Provenance       string
+	Year             int
+	XMLBase          string
+	IconURL          string
+	ImageDescription string
+	SkipHours        []int
+	SkipDays         []string
+}
+
+// data for the "item" template
+type itemData struct {
+	ID       uint64
+	GUID     string
+	Title    string
+	Text     string
+	ReadMore string
+	Link     string
+	PubDate  time.Time
+}
+
+// functions available to templates
+var templateFuncs = template.FuncMap{
+	"xml": func(s string) string {
+		return string(xmlutil.AppendEscaped(nil, s))
+	},
+	"rfc822": func(ts time.Time) string {
+		return ts.In(outputTZ).Format(time.RFC1123Z)
+	},
+}
+
+// load output template from the given file; the template must define "header", "item",
+// and "footer" templates
+func loadTemplate(path string) error {
+	src, err := os.ReadFile(path)
+
+	if err != nil {
+		return failure("reading template", err)
+	}
+
+	tmpl, err := template.New(filepath.Base(path)).Funcs(templateFuncs).Parse(string(src))
+
+	if err != nil {
+		return failure("template", err)
+	}
+
+	for _, name := range [...]string{"header", "item", "footer"} {
+		if tmpl.Lookup(name) == nil {
+			return errors.New("template " + strconv.Quote(path) + " does not define " + strconv.Quote(name))
+		}
+	}
+
+	// the footer does not depend on anything, so it is rendered only once
+	var buff bytes.Buffer
+
+	if err = tmpl.ExecuteTemplate(&buff, "footer", nil); err != nil {
+		return failure("template", err)
+	}
+
+	feedTemplate, xmlFooter, itemEnd = tmpl, buff.String(), ""
+	return nil
+}
+
+// render channel header from the template
+func renderHeader(data headerData) ([]byte, error) {
+	var buff bytes.Buffer
+
+	if err := feedTemplate.ExecuteTemplate(&buff, "header", &data); err != nil {
+		return nil, failure("template", err)
+	}
+
+	return buff.Bytes(), nil
+}
+
+// render news item from the template, appending to the given slice
+func renderItem(dest []byte, news *NewsItem) ([]byte, error) {
+	buff := bytes.NewBuffer(dest)
+
+	err := feedTemplate.ExecuteTemplate(buff, "item", &itemData{
+		ID:       news.id,
+		GUID:     news.guid,
+		Title:    news.title,
+		Text:     news.text,
+		ReadMore: readMoreSuffix(news),
+		Link:     news.link,
+		PubDate:  news.ts,
+	})
+
+	if err != nil {
+		return nil, failure("template", err)
+	}
+
+	return buff.Bytes(), nil
+}
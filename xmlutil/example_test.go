@@ -0,0 +1,41 @@
+package xmlutil_test
+
+import (
+	"fmt"
+
+	"github.com/maxim2266/vesti-rss/xmlutil"
+)
+
+func ExampleAppendEscaped() {
+	fmt.Println(string(xmlutil.AppendEscaped([]byte("text: "), "Tom & Jerry <3\x00")))
+	// Output:
+	// text: Tom &amp; Jerry &lt;3�
+}
+
+func ExampleAppendTag() {
+	fmt.Println(string(xmlutil.AppendTag(nil, "title", `Fish & "chips"`)))
+	// Output:
+	// <title>Fish &amp; &quot;chips&quot;</title>
+}
+
+func ExampleAppendAttr() {
+	fmt.Println(string(append(xmlutil.AppendAttr([]byte("<a"), "href", "https://example.com/?a=1&b=2"), "/>"...)))
+	// Output:
+	// <a href="https://example.com/?a=1&amp;b=2"/>
+}
+
+func ExampleAppendCDATA() {
+	fmt.Println(string(xmlutil.AppendCDATA(nil, "if a[b[0]]>c")))
+	// Output:
+	// <![CDATA[if a[b[0]]]]><![CDATA[>c]]>
+}
+
+func ExampleTruncateEscaped() {
+	text := xmlutil.AppendEscaped(nil, "Tom & Jerry")
+
+	fmt.Printf("%q\n", xmlutil.TruncateEscaped(text, 6))
+	fmt.Printf("%q\n", xmlutil.TruncateEscaped(text, 9))
+	// Output:
+	// "Tom "
+	// "Tom &amp;"
+}
@@ -0,0 +1,156 @@
+// Package xmlutil provides helpers for composing XML text directly in byte slices. All the
+// functions append to the given slice and return the result, in the style of strconv.AppendInt.
+// Characters that are not allowed in XML (https://www.w3.org/TR/xml/#charsets), as well as
+// invalid UTF-8 sequences, are replaced with U+FFFD, so the output is always well-formed.
+package xmlutil
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+// AppendEscaped appends the given text to the given byte slice, with XML entities escaped.
+func AppendEscaped(dest []byte, text string) []byte {
+	// fast path for the most common case
+	if !needsEscaping(text) {
+		return append(dest, text...)
+	}
+
+	var esc string
+
+	last := 0
+
+	for i := 0; i < len(text); {
+		r, width := utf8.DecodeRuneInString(text[i:])
+
+		switch r {
+		case '"':
+			esc = "&quot;"
+		case '\'':
+			esc = "&apos;"
+		case '&':
+			esc = "&amp;"
+		case '<':
+			esc = "&lt;"
+		case '>':
+			esc = "&gt;"
+		default:
+			if isValidXmlChar(r) && (r != utf8.RuneError || width != 1) {
+				i += width
+				continue
+			}
+
+			esc = "\uFFFD"
+		}
+
+		dest = append(append(dest, text[last:i]...), esc...)
+		i += width
+		last = i
+	}
+
+	return append(dest, text[last:]...)
+}
+
+// AppendTag appends XML element with the given name and text content, as in <name>text</name>.
+// The name is not validated.
+func AppendTag(dest []byte, name, text string) []byte {
+	dest = AppendEscaped(append(append(append(dest, '<'), name...), '>'), text)
+
+	return append(append(append(dest, "</"...), name...), '>')
+}
+
+// AppendAttr appends XML attribute with the given name and value, preceded by a space,
+// as in ` name="value"`. The name is not validated.
+func AppendAttr(dest []byte, name, value string) []byte {
+	dest = AppendEscaped(append(append(append(dest, ' '), name...), `="`...), value)
+
+	return append(dest, '"')
+}
+
+// AppendCDATA appends the given text as XML CDATA section. Any "]]>" sequence in the text
+// is split between two adjacent sections.
+func AppendCDATA(dest []byte, text string) []byte {
+	dest = append(dest, "<![CDATA["...)
+
+	for i := 0; i < len(text); {
+		if strings.HasPrefix(text[i:], "]]>") {
+			dest = append(dest, "]]]]><![CDATA[>"...)
+			i += 3
+			continue
+		}
+
+		r, width := utf8.DecodeRuneInString(text[i:])
+
+		if isValidXmlChar(r) && (r != utf8.RuneError || width != 1) {
+			dest = append(dest, text[i:i+width]...)
+		} else {
+			dest = append(dest, "\uFFFD"...)
+		}
+
+		i += width
+	}
+
+	return append(dest, "]]>"...)
+}
+
+// check if the text contains any XML special or invalid characters, without decoding runes;
+// in a valid UTF-8 string, the only invalid non-ASCII characters are U+FFFE and U+FFFF
+func needsEscaping(text string) bool {
+	for i := 0; i < len(text); i++ {
+		if c := text[i]; attention[c] {
+			if c != 0xEF || (i+2 < len(text) && text[i+1] == 0xBF && text[i+2] >= 0xBE) {
+				return true
+			}
+		}
+	}
+
+	return !utf8.ValidString(text)
+}
+
+// bytes that need further attention when checking for characters to escape
+var attention = func() (tab [256]bool) {
+	for c := range 0x20 {
+		tab[c] = c != 0x09 && c != 0x0A && c != 0x0D
+	}
+
+	for _, c := range []byte{'"', '\'', '&', '<', '>', 0xEF} {
+		tab[c] = true
+	}
+
+	return
+}()
+
+// TruncateEscaped cuts the given escaped XML text to at most n bytes, without breaking
+// UTF-8 sequences or XML entities.
+func TruncateEscaped(text []byte, n int) []byte {
+	if n >= len(text) {
+		return text
+	}
+
+	if n <= 0 {
+		return text[:0]
+	}
+
+	// step back to a rune boundary
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+
+	// step back over any incomplete entity
+	if i := bytes.LastIndexByte(text[:n], '&'); i >= 0 && bytes.IndexByte(text[i:n], ';') < 0 {
+		n = i
+	}
+
+	return text[:n]
+}
+
+// check if the rune is a valid XML character, as in section 2.2 of https://www.w3.org/TR/xml/#charsets
+func isValidXmlChar(r rune) bool {
+	return r == 0x09 ||
+		r == 0x0A ||
+		r == 0x0D ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
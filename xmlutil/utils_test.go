@@ -0,0 +1,240 @@
+package xmlutil
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// seed corpus for the fuzz tests
+var fuzzSeeds = []string{
+	"",
+	"plain text",
+	"Новости дня: \"Вести\" & <партнёры>",
+	"]]>",
+	"a]]]>b]]>",
+	"tab\tnew line\ncarriage return\r\n",
+	"\x00\x01\x1F\x7F",
+	"�￾￿",
+	"\xEF\xBF\xBE\xEF\xBF\xBD",
+	"\xEF\xBF",
+	"\xFF\xFE invalid UTF-8 \xC0\x80",
+	"😀 世界",
+}
+
+// expected text after a round trip through the XML parser: invalid characters are replaced
+// with U+FFFD, and line endings are normalised as in section 2.11 of the XML spec
+func normalised(text string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(text); {
+		r, width := utf8.DecodeRuneInString(text[i:])
+
+		if isValidXmlChar(r) && (r != utf8.RuneError || width != 1) {
+			b.WriteString(text[i : i+width])
+		} else {
+			b.WriteRune(utf8.RuneError)
+		}
+
+		i += width
+	}
+
+	return strings.ReplaceAll(strings.ReplaceAll(b.String(), "\r\n", "\n"), "\r", "\n")
+}
+
+// parse the given XML document, and return the text content of its root element
+func parseText(t *testing.T, doc []byte) string {
+	var root struct {
+		Text string `xml:",chardata"`
+	}
+
+	if err := xml.Unmarshal(doc, &root); err != nil {
+		t.Fatalf("malformed XML %q: %s", doc, err)
+	}
+
+	return root.Text
+}
+
+func FuzzAppendEscaped(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, text string) {
+		// element content
+		doc := append(AppendEscaped([]byte("<a>"), text), "</a>"...)
+
+		if got, exp := parseText(t, doc), normalised(text); got != exp {
+			t.Fatalf("text mismatch: got %q, expected %q", got, exp)
+		}
+
+		// attribute value
+		doc = append(AppendAttr([]byte("<a"), "b", text), "/>"...)
+
+		if err := xml.Unmarshal(doc, new(struct{})); err != nil {
+			t.Fatalf("malformed XML %q: %s", doc, err)
+		}
+	})
+}
+
+func FuzzAppendCDATA(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, text string) {
+		doc := append(AppendCDATA([]byte("<a>"), text), "</a>"...)
+
+		if got, exp := parseText(t, doc), normalised(text); got != exp {
+			t.Fatalf("text mismatch: got %q, expected %q", got, exp)
+		}
+	})
+}
+
+func FuzzTruncateEscaped(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s, 5)
+	}
+
+	f.Fuzz(func(t *testing.T, text string, n int) {
+		esc := AppendEscaped(nil, text)
+		res := TruncateEscaped(esc, n)
+
+		if len(res) > max(n, 0) || !bytes.HasPrefix(esc, res) {
+			t.Fatalf("invalid truncation of %q to %d bytes: %q", esc, n, res)
+		}
+
+		parseText(t, append(append([]byte("<a>"), res...), "</a>"...))
+	})
+}
+
+func TestTruncateEscaped(t *testing.T) {
+	cases := []struct {
+		text string
+		n    int
+		exp  string
+	}{
+		{"abc", 5, "abc"},
+		{"abc", 3, "abc"},
+		{"abc", 2, "ab"},
+		{"abc", 0, ""},
+		{"abc", -1, ""},
+		{"ab&amp;c", 2, "ab"},
+		{"ab&amp;c", 3, "ab"},
+		{"ab&amp;c", 6, "ab"},
+		{"ab&amp;c", 7, "ab&amp;"},
+		{"ab&amp;c", 8, "ab&amp;c"},
+		{"Привет", 1, ""},
+		{"Привет", 2, "П"},
+		{"Привет", 3, "П"},
+		{"Привет", 4, "Пр"},
+		{"a😀", 4, "a"},
+		{"a😀", 5, "a😀"},
+	}
+
+	for _, c := range cases {
+		if got := string(TruncateEscaped([]byte(c.text), c.n)); got != c.exp {
+			t.Errorf("TruncateEscaped(%q, %d): got %q, expected %q", c.text, c.n, got, c.exp)
+		}
+	}
+}
+
+// reference implementation of needsEscaping, decoding each rune
+func needsEscapingSlow(text string) bool {
+	for i := 0; i < len(text); {
+		r, width := utf8.DecodeRuneInString(text[i:])
+
+		switch {
+		case r == '"' || r == '\'' || r == '&' || r == '<' || r == '>':
+			return true
+		case !isValidXmlChar(r) || (r == utf8.RuneError && width == 1):
+			return true
+		}
+
+		i += width
+	}
+
+	return false
+}
+
+func TestNeedsEscaping(t *testing.T) {
+	inputs := append([]string(nil), fuzzSeeds...)
+
+	// all the characters with the lead byte 0xEF, including U+FFFE and U+FFFF,
+	// alone and before or after other text
+	for b1 := 0x80; b1 <= 0xBF; b1++ {
+		for b2 := 0x80; b2 <= 0xBF; b2++ {
+			c := string([]byte{0xEF, byte(b1), byte(b2)})
+			inputs = append(inputs, c, "Вести"+c, c+"Вести", c[:2], "x"+c[:2])
+		}
+	}
+
+	for _, s := range inputs {
+		if got, exp := needsEscaping(s), needsEscapingSlow(s); got != exp {
+			t.Errorf("needsEscaping(%q): got %t, expected %t", s, got, exp)
+		}
+	}
+}
+
+func FuzzNeedsEscaping(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, text string) {
+		if got, exp := needsEscaping(text), needsEscapingSlow(text); got != exp {
+			t.Fatalf("needsEscaping(%q): got %t, expected %t", text, got, exp)
+		}
+	})
+}
+
+// realistic news titles, most of them without any characters to escape
+var titles = []string{
+	"Путин провел совещание с членами Совбеза",
+	"В Москве ожидается до 15 сантиметров снега",
+	"Курс доллара опустился ниже 90 рублей впервые с лета",
+	"Ученые назвали неожиданную причину бессонницы",
+	"\"Зенит\" обыграл \"Спартак\" в матче РПЛ",
+	"Синоптики рассказали о погоде на выходные",
+	"Минфин & ЦБ: ставка останется без изменений",
+	"Ёлки на Манежной площади установят к 1 декабря",
+}
+
+func BenchmarkAppendEscaped(b *testing.B) {
+	var size int
+
+	for _, s := range titles {
+		size += len(s)
+	}
+
+	buff := make([]byte, 0, 1024)
+
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+
+	for range b.N {
+		for _, s := range titles {
+			buff = AppendEscaped(buff[:0], s)
+		}
+	}
+}
+
+func BenchmarkNeedsEscaping(b *testing.B) {
+	for _, bench := range [...]struct {
+		name string
+		fn   func(string) bool
+	}{
+		{"fast", needsEscaping},
+		{"slow", needsEscapingSlow},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for range b.N {
+				for _, s := range titles {
+					bench.fn(s)
+				}
+			}
+		})
+	}
+}